@@ -0,0 +1,182 @@
+package lisp
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"sync/atomic"
+)
+
+var gensymCount int64 // gensym 计数
+
+func (self *Interpreter) builtins() map[string]Object { // 全局变量/函数，每个解释器一份
+	env := map[string]Object{
+		"nil": nil, // 空值
+		"+": func(v []Object) Object { // 连加支持
+			nums, err := numArgs("+", v, 0, -1)
+			if err != nil {
+				return err
+			}
+			var res Object = int64(0)
+			for _, i := range nums {
+				res = NumAdd(res, i)
+			}
+			return res
+		},
+		"-": numFold("-", NumSub), // 连减支持
+		"*": func(v []Object) Object { // 连乘支持
+			nums, err := numArgs("*", v, 0, -1)
+			if err != nil {
+				return err
+			}
+			var res Object = int64(1)
+			for _, i := range nums {
+				res = NumMul(res, i)
+			}
+			return res
+		},
+		"/":  numFold("/", NumDiv), // 连除支持，整数相除得到分数
+		"^":  numOp2("^", NumPow),  // 指数
+		">":  numCmp(">", func(c int) bool { return c > 0 }),
+		">=": numCmp(">=", func(c int) bool { return c >= 0 }),
+		"<":  numCmp("<", func(c int) bool { return c < 0 }),
+		"<=": numCmp("<=", func(c int) bool { return c <= 0 }),
+		"==": func(v []Object) Object { // 相等，可比较数字、字符串、列表、哈希表、集合等
+			if err := checkArity("==", v, 2, 2); err != nil {
+				return err
+			}
+			return Equal(v[0], v[1])
+		},
+		"!=": func(v []Object) Object {
+			if err := checkArity("!=", v, 2, 2); err != nil {
+				return err
+			}
+			return !Equal(v[0], v[1])
+		},
+		"&&": func(v []Object) Object { // 与
+			bs, err := boolArgs("&&", v, 0, -1)
+			if err != nil {
+				return err
+			}
+			for _, b := range bs {
+				if !b {
+					return false
+				}
+			}
+			return true
+		},
+		"||": func(v []Object) Object {
+			bs, err := boolArgs("||", v, 0, -1)
+			if err != nil {
+				return err
+			}
+			for _, b := range bs {
+				if b {
+					return true
+				}
+			}
+			return false
+		},
+		"!": func(v []Object) Object {
+			bs, err := boolArgs("!", v, 1, 1)
+			if err != nil {
+				return err
+			}
+			return !bs[0]
+		},
+		"sin": math1("sin", math.Sin),
+		"cos": math1("cos", math.Cos),
+		"tan": math1("tan", math.Tan),
+		"mod": numOp2("mod", NumRem), // 取余
+		"%":   numOp2("%", NumRem),
+		"exp": math1("exp", math.Exp),
+		"log": math1("log", math.Log), // 以 e为底
+		"out": func(v []Object) Object { // 输出函数，参数以空格分隔
+			items := make([]string, len(v))
+			for i, item := range v {
+				items[i] = ToString(item, false)
+			}
+			fmt.Fprintln(self.Out, strings.Join(items, " "))
+			return nil
+		},
+		"val": func(v []Object) Object { // 显示值，多个值时返回列表
+			if len(v) == 1 {
+				return v[0]
+			}
+			return append([]Object{}, v...)
+		},
+		"gensym": func(v []Object) Object { // 生成唯一的符号，用于宏中的临时变量
+			if err := checkArity("gensym", v, 0, 1); err != nil {
+				return err
+			}
+			prefix := "G__"
+			if len(v) == 1 {
+				prefix = fmt.Sprint(v[0])
+			}
+			return Sym(fmt.Sprintf("%v%v", prefix, atomic.AddInt64(&gensymCount, 1)))
+		},
+		// 其余可自行添加
+	}
+	for k, v := range listBuiltins() {
+		env[k] = v
+	}
+	for k, v := range mapBuiltins() {
+		env[k] = v
+	}
+	for k, v := range funcBuiltins() {
+		env[k] = v
+	}
+	for k, v := range errorBuiltins() {
+		env[k] = v
+	}
+	return env
+}
+
+func math1(name string, f func(float64) float64) func([]Object) Object { // 包装单参数数学函数
+	return func(v []Object) Object {
+		nums, err := numArgs(name, v, 1, 1)
+		if err != nil {
+			return err
+		}
+		return f(ToFloat(nums[0]))
+	}
+}
+
+func numOp2(name string, f func(Object, Object) Object) func([]Object) Object { // 包装双参数运算
+	return func(v []Object) Object {
+		nums, err := numArgs(name, v, 2, 2)
+		if err != nil {
+			return err
+		}
+		return f(nums[0], nums[1])
+	}
+}
+
+func numFold(name string, f func(Object, Object) Object) func([]Object) Object { // 包装从左到右连续运算
+	return func(v []Object) Object {
+		nums, err := numArgs(name, v, 1, -1)
+		if err != nil {
+			return err
+		}
+		res := nums[0]
+		for i := 1; i < len(nums); i++ {
+			if res = f(res, nums[i]); IsError(res) {
+				return res
+			}
+		}
+		return res
+	}
+}
+
+func numCmp(name string, test func(int) bool) func([]Object) Object { // 包装比较运算
+	return func(v []Object) Object {
+		nums, err := numArgs(name, v, 2, 2)
+		if err != nil {
+			return err
+		}
+		if isNaN(nums[0]) || isNaN(nums[1]) { // NaN 无法比较大小
+			return false
+		}
+		return test(NumCmp(nums[0], nums[1]))
+	}
+}
@@ -0,0 +1,514 @@
+package lisp
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+	"math"
+	"math/big"
+	"os"
+)
+
+// 字节码缓存文件格式：文件头、源码的sha256、顶层代码数、各顶层代码
+// 源码改变或格式版本不同时缓存失效，重新编译
+
+const CacheExt = ".lbc" // 缓存文件扩展名，与源文件同名同目录
+
+const cacheMagic = "LBC\x03" // 格式改变时修改版本号
+
+const ( // 值的类型标记
+	tagNil = iota
+	tagFalse
+	tagTrue
+	tagInt
+	tagFloat
+	tagBig
+	tagRat
+	tagStr
+	tagSym
+	tagList    // 新的列表
+	tagListRef // 已出现过的列表，保持共享
+	tagError
+)
+
+type encoder struct {
+	w     *bufio.Writer
+	strs  map[string]int
+	lists map[*Object]int
+	nodes map[*Object]*NodePos
+}
+
+func (self *encoder) uint(n uint64) {
+	var buf [binary.MaxVarintLen64]byte
+	self.w.Write(buf[:binary.PutUvarint(buf[:], n)])
+}
+
+func (self *encoder) int(n int64) {
+	var buf [binary.MaxVarintLen64]byte
+	self.w.Write(buf[:binary.PutVarint(buf[:], n)])
+}
+
+func (self *encoder) str(s string) { // 字符串只写一次，之后写下标
+	if i, ok := self.strs[s]; ok {
+		self.uint(uint64(i))
+		return
+	}
+	self.strs[s] = len(self.strs)
+	self.uint(uint64(len(self.strs) - 1))
+	self.uint(uint64(len(s)))
+	self.w.WriteString(s)
+}
+
+func (self *encoder) obj(v Object) {
+	switch x := v.(type) {
+	case nil:
+		self.w.WriteByte(tagNil)
+	case bool:
+		if x {
+			self.w.WriteByte(tagTrue)
+		} else {
+			self.w.WriteByte(tagFalse)
+		}
+	case int64:
+		self.w.WriteByte(tagInt)
+		self.int(x)
+	case float64:
+		self.w.WriteByte(tagFloat)
+		self.uint(math.Float64bits(x))
+	case *big.Int:
+		self.w.WriteByte(tagBig)
+		self.str(x.String())
+	case *big.Rat:
+		self.w.WriteByte(tagRat)
+		self.str(x.String())
+	case Str:
+		self.w.WriteByte(tagStr)
+		self.str(string(x))
+	case *Symbol:
+		self.w.WriteByte(tagSym)
+		self.str(x.Name)
+	case []Object:
+		if len(x) > 0 {
+			if i, ok := self.lists[&x[0]]; ok {
+				self.w.WriteByte(tagListRef)
+				self.uint(uint64(i))
+				return
+			}
+			self.lists[&x[0]] = len(self.lists)
+		}
+		self.w.WriteByte(tagList)
+		self.uint(uint64(len(x)))
+		if len(x) == 0 {
+			if x == nil { // 区分 () 读入的nil列表与空列表
+				self.w.WriteByte(0)
+			} else {
+				self.w.WriteByte(1)
+			}
+			return
+		}
+		for _, item := range x {
+			self.obj(item)
+		}
+		np := self.nodes[&x[0]] // 节点位置，文件名在读取时补全
+		if np == nil {
+			self.w.WriteByte(0)
+			return
+		}
+		self.w.WriteByte(1)
+		self.pos(np.Pos)
+		self.uint(uint64(len(np.Items)))
+		for _, p := range np.Items {
+			self.pos(p)
+		}
+	case *LispError:
+		self.w.WriteByte(tagError)
+		self.uint(uint64(x.Kind))
+		self.str(x.Msg)
+	default:
+		panic(cacheError{}) // 常量中不会出现其他类型
+	}
+}
+
+func (self *encoder) pos(p Pos) {
+	self.uint(uint64(p.Line))
+	self.uint(uint64(p.Col))
+}
+
+func (self *encoder) proto(p *Proto) {
+	self.str(p.name)
+	self.obj(p.args)
+	self.obj(p.body)
+	self.uint(uint64(len(p.code)))
+	for i, in := range p.code {
+		self.w.WriteByte(byte(in.Op))
+		self.int(int64(in.A))
+		self.int(int64(in.B))
+		self.int(int64(p.sites[i].node))
+		self.int(int64(p.sites[i].idx))
+	}
+	self.uint(uint64(len(p.consts)))
+	for _, c := range p.consts {
+		self.obj(c)
+	}
+	self.uint(uint64(len(p.nodes)))
+	for _, n := range p.nodes {
+		self.obj(n)
+	}
+	self.uint(uint64(len(p.protos)))
+	for _, sub := range p.protos {
+		self.proto(sub)
+	}
+}
+
+type cacheError struct{} // 缓存损坏，读取时以panic中止
+
+type decoder struct {
+	r     *bytes.Reader
+	file  string
+	strs  []string
+	lists [][]Object
+	nodes map[*Object]*NodePos
+}
+
+func (self *decoder) byte() byte {
+	b, err := self.r.ReadByte()
+	if err != nil {
+		panic(cacheError{})
+	}
+	return b
+}
+
+func (self *decoder) uint() uint64 {
+	n, err := binary.ReadUvarint(self.r)
+	if err != nil {
+		panic(cacheError{})
+	}
+	return n
+}
+
+func (self *decoder) int() int64 {
+	n, err := binary.ReadVarint(self.r)
+	if err != nil {
+		panic(cacheError{})
+	}
+	return n
+}
+
+func (self *decoder) len() int { // 读取长度，不能超过剩余的字节数
+	n := self.uint()
+	if n > uint64(self.r.Len()) {
+		panic(cacheError{})
+	}
+	return int(n)
+}
+
+func (self *decoder) str() string {
+	i := self.uint()
+	if i < uint64(len(self.strs)) {
+		return self.strs[i]
+	}
+	if i != uint64(len(self.strs)) {
+		panic(cacheError{})
+	}
+	buf := make([]byte, self.len())
+	if _, err := io.ReadFull(self.r, buf); err != nil {
+		panic(cacheError{})
+	}
+	self.strs = append(self.strs, string(buf))
+	return string(buf)
+}
+
+func (self *decoder) obj() Object {
+	switch self.byte() {
+	case tagNil:
+		return nil
+	case tagFalse:
+		return false
+	case tagTrue:
+		return true
+	case tagInt:
+		return self.int()
+	case tagFloat:
+		return math.Float64frombits(self.uint())
+	case tagBig:
+		if n, ok := new(big.Int).SetString(self.str(), 10); ok {
+			return n
+		}
+	case tagRat:
+		if r, ok := new(big.Rat).SetString(self.str()); ok {
+			return r
+		}
+	case tagStr:
+		return Str(self.str())
+	case tagSym:
+		return Sym(self.str())
+	case tagList:
+		n := self.len()
+		if n == 0 {
+			if self.byte() == 0 {
+				return []Object(nil)
+			}
+			return []Object{}
+		}
+		lt := make([]Object, n)
+		self.lists = append(self.lists, lt)
+		for i := range lt {
+			lt[i] = self.obj()
+		}
+		if self.byte() == 1 {
+			np := &NodePos{Pos: self.pos(), Items: make([]Pos, self.len())}
+			for i := range np.Items {
+				np.Items[i] = self.pos()
+			}
+			self.nodes[&lt[0]] = np
+		}
+		return lt
+	case tagListRef:
+		if i := self.uint(); i < uint64(len(self.lists)) {
+			return self.lists[i]
+		}
+	case tagError:
+		kind := ErrKind(self.uint())
+		return NewError(kind, "%s", self.str())
+	}
+	panic(cacheError{})
+}
+
+func (self *decoder) pos() Pos {
+	return Pos{self.file, int(self.uint()), int(self.uint())}
+}
+
+func (self *decoder) list() []Object {
+	lt, ok := self.obj().([]Object)
+	if !ok {
+		panic(cacheError{})
+	}
+	return lt
+}
+
+func (self *decoder) proto() *Proto {
+	p := &Proto{name: self.str(), args: self.list(), body: self.obj()}
+	p.code = make([]Instr, self.len())
+	p.sites = make([]site, len(p.code))
+	for i := range p.code {
+		p.code[i] = Instr{Op(self.byte()), int32(self.int()), int32(self.int())}
+		p.sites[i] = site{int32(self.int()), int32(self.int())}
+	}
+	p.consts = make([]Object, self.len())
+	for i := range p.consts {
+		p.consts[i] = self.obj()
+	}
+	p.nodes = make([][]Object, self.len())
+	for i := range p.nodes {
+		p.nodes[i] = self.list()
+	}
+	p.protos = make([]*Proto, self.len())
+	for i := range p.protos {
+		p.protos[i] = self.proto()
+	}
+	var err *LispError
+	if p.sig, err = parseParams(p.name, p.args); err != nil || !p.valid() {
+		panic(cacheError{})
+	}
+	return p
+}
+
+func (self *Proto) valid() bool { // 检查下标及常量类型，避免损坏的缓存导致虚拟机崩溃
+	konst := func(i int32) Object {
+		if i < 0 || i >= int32(len(self.consts)) {
+			return nil
+		}
+		return self.consts[i]
+	}
+	for i, in := range self.code {
+		if s := self.sites[i]; s.node >= int32(len(self.nodes)) || (s.node < 0 && (in.Op == opGetFn || in.Op == opGetFnTail || in.Op == opEval)) {
+			return false
+		}
+		var ok bool
+		switch in.Op {
+		case opConst:
+			ok = in.A >= 0 && in.A < int32(len(self.consts))
+		case opGet, opSet, opDef, opAssign:
+			_, ok = konst(in.A).(*Symbol)
+		case opGetFn, opGetFnTail:
+			_, ok = konst(in.A).(*Symbol)
+			ok = ok && in.B >= 0 && in.B <= int32(len(self.code))
+		case opJump, opStmt:
+			ok = in.A >= 0 && in.A <= int32(len(self.code))
+		case opJumpFalse:
+			_, ok = konst(in.B).(Str)
+			ok = ok && in.A >= 0 && in.A <= int32(len(self.code))
+		case opError:
+			_, ok = konst(in.A).(*LispError)
+		case opLoop:
+			_, ok = konst(in.B).(Str)
+			ok = ok && in.A >= 0 && in.A <= int32(len(self.code))
+		case opMatch:
+			_, ok = konst(in.B).([]Object)
+			ok = ok && in.A >= 0 && in.A <= int32(len(self.code))
+		case opIter:
+			_, ok = konst(in.A).(Str)
+		case opNext:
+			ok = in.A >= 0 && in.A <= int32(len(self.code))
+		case opUnpack:
+			_, ok = konst(in.B).(Str)
+			ok = ok && in.A >= 2
+		case opFn:
+			ok = in.A >= 0 && in.A < int32(len(self.protos))
+		case opCall, opTailCall, opList:
+			ok = in.A >= 0
+		case opPop, opEnter, opLeave, opCheckFn, opRet, opEval, opNip:
+			ok = true
+		}
+		if !ok {
+			return false
+		}
+	}
+	return self.balanced()
+}
+
+func (self *Proto) balanced() bool { // 检查每条指令处的栈深度及语句块层数，各条路径到达同一指令时应一致
+	type depth struct{ stack, envs int32 }
+	seen := make([]bool, len(self.code)+1)
+	at := make([]depth, len(self.code)+1)
+	work := []int32{0}
+	seen[0] = true
+	flow := func(pc int32, d depth) bool { // 以深度d到达pc
+		if seen[pc] {
+			return at[pc] == d
+		}
+		seen[pc], at[pc] = true, d
+		work = append(work, pc)
+		return true
+	}
+	for len(work) > 0 {
+		pc := work[len(work)-1]
+		work = work[:len(work)-1]
+		d := at[pc]
+		if pc == int32(len(self.code)) { // 结束时返回栈顶
+			if d.stack < 1 {
+				return false
+			}
+			continue
+		}
+		in := self.code[pc]
+		need, next, jump, target := int32(0), d, depth{-1, -1}, in.A // 所需的栈深度，顺序执行及跳转后的深度，跳转目标
+		switch in.Op {
+		case opConst, opGet, opFn, opEval:
+			next.stack++
+		case opGetFn, opGetFnTail:
+			next.stack++
+			jump, target = next, in.B
+		case opSet, opDef, opAssign, opCheckFn, opRet, opIter:
+			need = 1
+		case opPop:
+			need, next.stack = 1, d.stack-1
+		case opJump:
+			jump, next = d, depth{-1, -1}
+		case opJumpFalse:
+			need, next.stack = 1, d.stack-1
+			jump = next
+		case opMatch:
+			need, next.stack = 1, d.stack-1
+			jump = d
+		case opStmt:
+			need, next.stack = 1, d.stack-1
+			jump = d
+		case opLoop:
+			need = 1
+			jump = depth{d.stack - 1, d.envs}
+		case opNext:
+			need, next.stack = 1, d.stack+1
+			jump = d
+		case opUnpack:
+			need, next.stack = 1, d.stack-1+in.A
+		case opNip:
+			need, next.stack = 2, d.stack-1
+		case opEnter:
+			next.envs++
+		case opLeave:
+			if d.envs < 1 {
+				return false
+			}
+			next.envs--
+		case opCall, opTailCall:
+			need, next.stack = in.A+1, d.stack-in.A
+		case opList:
+			need, next.stack = in.A, d.stack-in.A+1
+		case opError:
+			next = depth{-1, -1}
+		}
+		if d.stack < need {
+			return false
+		}
+		if next.stack >= 0 && !flow(pc+1, next) || jump.stack >= 0 && !flow(target, jump) {
+			return false
+		}
+	}
+	return true
+}
+
+func (self *Interpreter) readCache(path, src, file string) (protos []*Proto) { // 读取缓存，不存在或失效时返回nil
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil
+	}
+	sum := sha256.Sum256([]byte(src))
+	head := cacheMagic + string(sum[:])
+	if !bytes.HasPrefix(data, []byte(head)) {
+		return nil
+	}
+	nodes := make(map[*Object]*NodePos) // 读取成功后才加入节点位置表
+	defer func() {
+		if r := recover(); r != nil {
+			if _, ok := r.(cacheError); !ok {
+				panic(r)
+			}
+			protos = nil
+			return
+		}
+		for k, v := range nodes {
+			self.nodes[k] = v
+		}
+	}()
+	d := &decoder{r: bytes.NewReader(data[len(head):]), file: file, nodes: nodes}
+	protos = make([]*Proto, d.len())
+	for i := range protos {
+		protos[i] = d.proto()
+	}
+	return protos
+}
+
+func (self *Interpreter) writeCache(path, src string, protos []*Proto) (err error) { // 写入缓存
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			if _, ok := r.(cacheError); !ok {
+				panic(r)
+			}
+			f.Close()
+			os.Remove(path)
+			err = io.ErrUnexpectedEOF
+		}
+	}()
+	e := &encoder{w: bufio.NewWriter(f), strs: make(map[string]int), lists: make(map[*Object]int), nodes: self.nodes}
+	sum := sha256.Sum256([]byte(src))
+	e.w.WriteString(cacheMagic)
+	e.w.Write(sum[:])
+	e.uint(uint64(len(protos)))
+	for _, p := range protos {
+		e.proto(p)
+	}
+	if err = e.w.Flush(); err != nil {
+		f.Close()
+		os.Remove(path)
+		return err
+	}
+	return f.Close()
+}
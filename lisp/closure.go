@@ -0,0 +1,593 @@
+package lisp
+
+import "strings"
+
+// 闭包编译：执行前把表达式转换为一棵Go闭包，结果与 Eval 相同
+// 变量在编译时解析为各层环境中的槽位，运行时逐层按下标取值，不再逐层按名查找
+// quasiquote、defmacro、load 等少用的结构及宏调用仍交给 Eval 执行
+
+type closure func(env *EnvType) Object // 编译后的表达式
+
+type scope struct { // 编译时的一层环境，与运行时的 EnvType 一一对应
+	names map[string]int // 本层声明的变量及其槽位
+	up    *scope         // 外层，编译单元的最外层为nil
+	fn    bool           // 是否为函数帧
+}
+
+func (self *scope) declare(name string) int { // 在本层声明变量，返回槽位；最外层对应的环境在运行时才确定，不分配槽位
+	if self.up == nil {
+		return -1
+	}
+	i, ok := self.names[name]
+	if !ok {
+		if self.names == nil {
+			self.names = make(map[string]int)
+		}
+		i = len(self.names)
+		self.names[name] = i
+	}
+	return i
+}
+
+func (self *scope) declareFn(name string) { // set/= 找不到变量时在函数帧定义，预先在函数帧声明
+	for s := self; s.up != nil; s = s.up {
+		if s.fn {
+			s.declare(name)
+			return
+		}
+	}
+}
+
+func (self *EnvType) frame(sc *scope) { // 按 sc 为本层分配槽位
+	if len(sc.names) == 0 {
+		return
+	}
+	self.scope = sc
+	self.slots = make([]Object, len(sc.names))
+	for i := range self.slots {
+		self.slots[i] = unset
+	}
+}
+
+func (self *EnvType) block(sc *scope) *EnvType { // 新建一层语句块环境
+	env := self.Copy()
+	env.frame(sc)
+	return env
+}
+
+func (self *EnvType) define(slot int, key string, val Object) { // 在本层定义，slot为编译时分配的槽位
+	if slot >= 0 {
+		self.slots[slot] = val
+	} else {
+		self.put(key, val)
+	}
+}
+
+type ref struct { // 编译时解析的变量引用
+	name  string
+	slots []int  // 编译单元内由内向外各层中的槽位，-1表示该层没有声明
+	sc    *scope // 引用所在的层，整个编译单元编译完后才能确定各层的槽位
+}
+
+func (self *ref) resolve() {
+	for s := self.sc; s.up != nil; s = s.up {
+		i, ok := s.names[self.name]
+		if !ok {
+			i = -1
+		}
+		self.slots = append(self.slots, i)
+	}
+}
+
+func (self *ref) get(env *EnvType) (Object, bool) { // 同 env.Lookup
+	e := env
+	for _, i := range self.slots {
+		if i >= 0 {
+			if v := e.slots[i]; v != unset {
+				return v, true
+			}
+		}
+		if e.vars != nil { // 由 Eval 等按名定义的变量
+			if v, ok := e.vars[self.name]; ok {
+				return v, true
+			}
+		}
+		e = e.up
+	}
+	return e.Lookup(self.name) // 编译单元之外按名查找
+}
+
+func (self *ref) set(env *EnvType, val Object) { // 同 env.Set
+	e := env
+	for _, i := range self.slots {
+		if e == env.fn {
+			e.define(i, self.name, val)
+			return
+		}
+		if i >= 0 && e.slots[i] != unset {
+			e.slots[i] = val
+			return
+		}
+		if _, ok := e.vars[self.name]; ok {
+			e.vars[self.name] = val
+			return
+		}
+		e = e.up
+	}
+	e.Set(self.name, val)
+}
+
+func (self *ref) assign(env *EnvType, val Object) bool { // 同 env.Assign
+	e := env
+	for _, i := range self.slots {
+		if i >= 0 && e.slots[i] != unset {
+			e.slots[i] = val
+			return true
+		}
+		if _, ok := e.vars[self.name]; ok {
+			e.vars[self.name] = val
+			return true
+		}
+		e = e.up
+	}
+	return e.Assign(self.name, val)
+}
+
+type closureCompiler struct {
+	refs []*ref
+}
+
+func CompileClosure(tree Object) func(*EnvType) Object { // 把顶层表达式编译为闭包
+	self := &closureCompiler{}
+	c := self.expr(tree, &scope{})
+	for _, r := range self.refs {
+		r.resolve()
+	}
+	return c
+}
+
+func (self *closureCompiler) ref(name string, sc *scope) *ref {
+	r := &ref{name: name, sc: sc}
+	if i := strings.Index(name, "/"); i > 0 && i < len(name)-1 {
+		return r // ns/name 总是按名查找
+	}
+	self.refs = append(self.refs, r)
+	return r
+}
+
+func raise(v []Object, idx int, kind ErrKind, format string, a ...interface{}) closure { // 执行到时才报告的结构错误
+	return func(*EnvType) Object {
+		err := NewError(kind, format, a...)
+		if idx >= 0 {
+			err.at(v, idx)
+		}
+		return err
+	}
+}
+
+func (self *closureCompiler) expr(tree Object, sc *scope) closure {
+	switch x := tree.(type) {
+	case []Object:
+		c := self.list(x, sc, false)
+		return func(env *EnvType) Object {
+			if DEBUG {
+				Debug("AST:", x)
+			}
+			res := c(env)
+			if err, ok := res.(*LispError); ok {
+				err.at(x, -1) // 记录出错节点
+			}
+			return res
+		}
+	case *Symbol:
+		if x.IsKeyword() {
+			break
+		}
+		r := self.ref(x.Name, sc)
+		return func(env *EnvType) Object {
+			if DEBUG {
+				Debug("AST:", x)
+			}
+			if v, ok := r.get(env); ok {
+				return v
+			}
+			if env.Strict() {
+				return NewError(UnboundSymbol, "未定义的变量 %v", x)
+			}
+			return x // 非严格模式下未定义的符号求值为自身
+		}
+	}
+	return func(*EnvType) Object {
+		if DEBUG {
+			Debug("AST:", tree)
+		}
+		return tree
+	}
+}
+
+func (self *closureCompiler) tail(tree Object, sc *scope) closure { // 尾部位置，同 evalTail
+	x, ok := tree.([]Object)
+	if !ok {
+		return self.expr(tree, sc)
+	}
+	c := self.list(x, sc, true)
+	return func(env *EnvType) Object {
+		if DEBUG {
+			Debug("AST:", x)
+		}
+		res := c(env)
+		if err, ok := res.(*LispError); ok {
+			err.at(x, -1)
+		}
+		return res
+	}
+}
+
+func (self *closureCompiler) block(exprs []Object, sc *scope) closure { // 同 evalBlock
+	stmts := make([]closure, len(exprs))
+	for i, e := range exprs {
+		stmts[i] = self.expr(e, sc)
+	}
+	return func(env *EnvType) Object {
+		for _, s := range stmts {
+			res := s(env)
+			switch res.(type) {
+			case Return, *LispError:
+				return res
+			}
+		}
+		return nil
+	}
+}
+
+func (self *closureCompiler) body(exprs []Object, sc *scope) closure { // 同 evalBody
+	stmts := make([]closure, len(exprs))
+	for i, e := range exprs {
+		stmts[i] = self.expr(e, sc)
+	}
+	return func(env *EnvType) Object {
+		var res Object
+		for _, s := range stmts {
+			res = s(env)
+			switch res.(type) {
+			case Return, *LispError:
+				return res
+			}
+		}
+		return res
+	}
+}
+
+func (self *closureCompiler) args(v []Object, sc *scope) func(*EnvType) ([]Object, *LispError) { // 同 Apply
+	cs := make([]closure, len(v)-1)
+	for i := range cs {
+		cs[i] = self.expr(v[i+1], sc)
+	}
+	return func(env *EnvType) ([]Object, *LispError) {
+		if len(cs) == 0 {
+			return nil, nil
+		}
+		res := make([]Object, len(cs))
+		for i, c := range cs {
+			val := c(env)
+			if err, ok := val.(*LispError); ok {
+				return nil, err.at(v, i+1)
+			}
+			res[i] = val
+		}
+		return res, nil
+	}
+}
+
+func (self *closureCompiler) list(v []Object, sc *scope, tail bool) closure { // 同 evalList
+	if len(v) == 0 {
+		return func(*EnvType) Object { return []Object{} }
+	}
+	sym, ok := v[0].(*Symbol)
+	if !ok { // 表达式开头，如 ((make-adder 3) 4)
+		head, args := self.expr(v[0], sc), self.args(v, sc)
+		return func(env *EnvType) Object {
+			f := head(env)
+			if IsError(f) {
+				return f
+			}
+			if !IsFunc(f) {
+				return NewError(TypeError, "%v 不是函数", ToString(f, true)).at(v, 0)
+			}
+			a, err := args(env)
+			if err != nil {
+				return err
+			}
+			if fc, ok := f.(Fn); ok && tail {
+				return &tailCall{fc, a}
+			}
+			return Invoke(f, a)
+		}
+	}
+	op := sym.Name
+	switch op {
+	case "set", "=", "def", "set!":
+		if len(v) != 3 {
+			return raise(v, -1, SyntaxError, "%v 结构错误！正确格式为：(%v name expr)", op, op)
+		}
+		name, ok := v[1].(*Symbol)
+		if !ok {
+			return raise(v, 1, SyntaxError, "%v 的变量名应为符号, 实际为 %v", op, v[1])
+		}
+		val := self.expr(v[2], sc)
+		switch op {
+		case "def":
+			slot := sc.declare(name.Name)
+			return func(env *EnvType) Object {
+				x := val(env)
+				if !IsError(x) {
+					env.define(slot, name.Name, x)
+				}
+				return x
+			}
+		case "set!":
+			r := self.ref(name.Name, sc)
+			return func(env *EnvType) Object {
+				x := val(env)
+				if IsError(x) {
+					return x
+				}
+				if !r.assign(env, x) {
+					return NewError(UnboundSymbol, "set! 的变量 %v 未定义", name).at(v, 1)
+				}
+				return x
+			}
+		}
+		sc.declareFn(name.Name)
+		r := self.ref(name.Name, sc)
+		return func(env *EnvType) Object {
+			x := val(env)
+			if !IsError(x) {
+				r.set(env, x)
+			}
+			return x
+		}
+	case "let":
+		return self.let(v, sc)
+	case "ret":
+		if len(v) == 2 {
+			c := self.tail(v[1], sc)
+			return func(env *EnvType) Object {
+				res := c(env)
+				if IsError(res) {
+					return res
+				}
+				return Return{res}
+			}
+		}
+		if len(v) == 1 {
+			return func(*EnvType) Object { return Return{nil} }
+		}
+		args := self.args(v, sc)
+		return func(env *EnvType) Object {
+			a, err := args(env)
+			if err != nil {
+				return err
+			}
+			return Return{a}
+		}
+	case "quote":
+		if len(v) != 2 {
+			return raise(v, -1, SyntaxError, "quote 需要1个参数")
+		}
+		return func(*EnvType) Object { return v[1] }
+	case "quasiquote", "unquote", "splice-unquote", "defmacro", "macroexpand", "load", "import":
+		return func(env *EnvType) Object { return evalList(v, env, tail) }
+	case "if":
+		return self.ifs(v, sc)
+	case "try":
+		return self.try(v, sc)
+	case "break", "continue":
+		ctl, err := parseLoopCtl(v)
+		if err != nil {
+			return func(*EnvType) Object {
+				_, err := parseLoopCtl(v)
+				return err
+			}
+		}
+		return func(*EnvType) Object { return Return{ctl} }
+	case "fn":
+		if len(v) == 3 {
+			return self.fn("", v, 1, sc)
+		}
+		if len(v) != 4 {
+			return raise(v, -1, SyntaxError, "fn 结构错误！正确格式为：(fn fn_name [x y ... ] {expr1 expr2 ...})")
+		}
+		name, ok := v[1].(*Symbol)
+		if !ok {
+			return raise(v, 1, SyntaxError, "fn 的函数名应为符号, 实际为 %v", v[1])
+		}
+		return self.fn(name.Name, v, 2, sc)
+	case "lambda":
+		if len(v) != 3 {
+			return raise(v, -1, SyntaxError, "lambda 结构错误！正确格式为：(lambda [x y ...] {expr1 expr2 ...})")
+		}
+		return self.fn("", v, 1, sc)
+	case "cond", "case", "switch", "when", "unless":
+		return self.branch(v, sc)
+	case "for-each", "dotimes":
+		if isEachForm(v) {
+			return self.each(v, sc)
+		}
+	case "for":
+		label, ci, err := loopLabel(v)
+		if err != nil {
+			return func(*EnvType) Object {
+				_, _, err := loopLabel(v) // 每次报告新的错误值
+				return err
+			}
+		}
+		exprs, ok := v[ci+1].([]Object)
+		if !ok {
+			return raise(v, ci+1, SyntaxError, "for 的循环体应为列表 {expr1 expr2 ...}")
+		}
+		fs := &scope{up: sc}
+		cond, body := self.expr(v[ci], fs), self.block(exprs, fs)
+		return func(env *EnvType) Object {
+			for_env := env.block(fs)
+			for {
+				c := cond(for_env)
+				if IsError(c) {
+					return c
+				}
+				du, ok := c.(bool)
+				if !ok {
+					return NewError(TypeError, "for 的判断条件应为bool, 实际为 %v", c).at(v, ci)
+				}
+				if !du {
+					return nil
+				}
+				if res := body(for_env); res != nil {
+					if ctl, ok := loopSignal(res, label); ok {
+						if ctl.brk {
+							return nil
+						}
+						continue
+					}
+					return res
+				}
+			}
+		}
+	}
+	r, args := self.ref(op, sc), self.args(v, sc)
+	return func(env *EnvType) Object {
+		f, ok := r.get(env)
+		if !ok {
+			return NewError(UnboundSymbol, "未定义的函数 %v", op).at(v, 0)
+		}
+		if fc, ok := f.(Fn); ok && fc.Macro { // 宏在运行时展开，交给 Eval 求值
+			code := MacroExpand(v, env)
+			if IsError(code) || !tail {
+				return Eval(code, env)
+			}
+			return evalTail(code, env)
+		}
+		if !IsFunc(f) {
+			return NewError(TypeError, "%v 不是函数", op).at(v, 0)
+		}
+		a, err := args(env)
+		if err != nil {
+			return err
+		}
+		if fc, ok := f.(Fn); ok && tail {
+			return &tailCall{fc, a}
+		}
+		return Invoke(f, a)
+	}
+}
+
+func (self *closureCompiler) let(v []Object, sc *scope) closure {
+	if len(v) != 3 {
+		return raise(v, -1, SyntaxError, "let 结构错误！正确格式为：(let [name1 expr1 name2 expr2 ...] {expr1 expr2 ...})")
+	}
+	if name, ok := v[1].(*Symbol); ok { // (let name expr) 同 def
+		slot, val := sc.declare(name.Name), self.expr(v[2], sc)
+		return func(env *EnvType) Object {
+			x := val(env)
+			if !IsError(x) {
+				env.define(slot, name.Name, x)
+			}
+			return x
+		}
+	}
+	binds, ok := v[1].([]Object)
+	if !ok || len(binds)%2 != 0 {
+		return raise(v, 1, SyntaxError, "let 的绑定应为 [name1 expr1 name2 expr2 ...]")
+	}
+	exprs, ok := v[2].([]Object)
+	if !ok {
+		return raise(v, 2, SyntaxError, "let 的语句块应为列表 {expr1 expr2 ...}")
+	}
+	ls := &scope{up: sc}
+	type bind struct {
+		name string
+		slot int
+		val  closure
+	}
+	var bs []bind
+	for i := 0; i < len(binds); i += 2 {
+		name, ok := binds[i].(*Symbol)
+		if !ok { // 前面的绑定照常求值，到这里才报错
+			bs = append(bs, bind{val: raise(v, 1, SyntaxError, "let 的变量名应为符号, 实际为 %v", binds[i])})
+			break
+		}
+		bs = append(bs, bind{name.Name, ls.declare(name.Name), self.expr(binds[i+1], ls)})
+	}
+	body := self.body(exprs, ls)
+	return func(env *EnvType) Object {
+		let_env := env.block(ls)
+		for _, b := range bs {
+			val := b.val(let_env)
+			if IsError(val) {
+				return val
+			}
+			let_env.define(b.slot, b.name, val)
+		}
+		return body(let_env)
+	}
+}
+
+func (self *closureCompiler) ifs(v []Object, sc *scope) closure {
+	if len(v) != 3 && len(v) != 4 {
+		return raise(v, -1, SyntaxError, "if 结构错误！正确格式为：(if (bool expr) {expr1 expr2 ...} {expr3 expr4 ...})")
+	}
+	cond := self.expr(v[1], sc)
+	bs := &scope{up: sc} // 两个分支只执行其一，共用一层
+	branches := make([]closure, len(v)-2)
+	for i := range branches {
+		if exprs, ok := v[i+2].([]Object); ok {
+			branches[i] = self.block(exprs, bs)
+		} else {
+			branches[i] = raise(v, i+2, SyntaxError, "if 结构错误！正确格式为：(if (bool expr) {expr1 expr2 ...} {expr3 expr4 ...})")
+		}
+	}
+	return func(env *EnvType) Object {
+		c := cond(env)
+		if IsError(c) {
+			return c
+		}
+		du, ok := c.(bool)
+		if !ok {
+			return NewError(TypeError, "if 的判断条件应为bool, 实际为 %v", c).at(v, 1)
+		}
+		if du {
+			return branches[0](env.block(bs))
+		} else if len(branches) == 2 {
+			return branches[1](env.block(bs))
+		}
+		return nil
+	}
+}
+
+func (self *closureCompiler) fn(name string, v []Object, i int, sc *scope) closure { // 由 v[i] 形参列表与 v[i+1] 函数体创建函数，有名字时同 fn 设置变量
+	res := makeFn(name, v, i, nil)
+	if IsError(res) {
+		return func(env *EnvType) Object { return makeFn(name, v, i, env) } // 每次执行报告新的错误值
+	}
+	proto := res.(Fn)
+	fs := &scope{up: sc, fn: true}
+	for _, name := range proto.sig.names() {
+		fs.declare(name)
+	}
+	proto.run, proto.scope = self.block(proto.Body.([]Object), fs), fs
+	if name == "" {
+		return func(env *EnvType) Object {
+			fn := proto
+			fn.Env = env
+			return fn
+		}
+	}
+	sc.declareFn(name)
+	r := self.ref(name, sc)
+	return func(env *EnvType) Object {
+		fn := proto
+		fn.Env = env
+		r.set(env, fn) // 函数捕获定义它的环境，递归时在该环境中就能找到自己
+		return fn
+	}
+}
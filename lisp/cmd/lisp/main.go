@@ -0,0 +1,34 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+
+	"github.com/pysrc/Make_Lisp/lisp"
+)
+
+func ExeFile(in *lisp.Interpreter, path string) { // 执行文件
+	if _, err := in.EvalFile(path); err != nil {
+		fmt.Println(err)
+	}
+}
+
+func main() {
+	strict := flag.Bool("strict", false, "严格模式：未定义的符号报错")
+	vm := flag.Bool("vm", false, "编译为字节码，由虚拟机执行")
+	cache := flag.Bool("cache", false, "与 -vm 一起使用，将字节码缓存到代码文件旁的 .lbc 文件")
+	flag.Parse()
+	in := lisp.New()
+	in.Strict = *strict
+	in.Engine = lisp.Closure
+	if *vm {
+		in.Engine = lisp.Bytecode
+		in.Cache = *cache
+	}
+	args := flag.Args()
+	if len(args) < 1 {
+		ExeIDLE(in)
+	} else {
+		ExeFile(in, args[0])
+	}
+}
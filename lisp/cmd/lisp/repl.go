@@ -0,0 +1,300 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pysrc/Make_Lisp/lisp"
+)
+
+// 交互式命令行：括号未闭合时继续读入下一行，S: 与 :E 之间按代码块读入
+// 输入历史保存在用户目录下的 .lisp_history，:history 查看，!n 重新执行第n条
+// 以 : 开头的为命令，见 commands
+
+const (
+	historyFile = ".lisp_history"
+	historyMax  = 1000 // 最多保留的历史条数
+)
+
+type history struct {
+	path  string // 历史文件，取不到用户目录时为空，只在内存中保留
+	items []string
+}
+
+func loadHistory() *history { // 读取历史文件，每行为一条引号括起的输入
+	h := &history{}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return h
+	}
+	h.path = filepath.Join(home, historyFile)
+	data, err := ioutil.ReadFile(h.path)
+	if err != nil {
+		return h
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		if s, err := strconv.Unquote(line); err == nil {
+			h.items = append(h.items, s)
+		}
+	}
+	if len(h.items) > historyMax { // 超出时截短并重写文件
+		h.items = h.items[len(h.items)-historyMax:]
+		var buf strings.Builder
+		for _, s := range h.items {
+			buf.WriteString(strconv.Quote(s) + "\n")
+		}
+		ioutil.WriteFile(h.path, []byte(buf.String()), 0600)
+	}
+	return h
+}
+
+func (self *history) add(entry string) {
+	self.items = append(self.items, entry)
+	if self.path == "" {
+		return
+	}
+	f, err := os.OpenFile(self.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return
+	}
+	fmt.Fprintln(f, strconv.Quote(entry))
+	f.Close()
+}
+
+func (self *history) print() { // 列出最近的20条
+	start := len(self.items) - 20
+	if start < 0 {
+		start = 0
+	}
+	for i := start; i < len(self.items); i++ {
+		fmt.Printf("%4d  %v\n", i+1, strings.Replace(self.items[i], "\n", "\n      ", -1))
+	}
+}
+
+func readLine(reader *bufio.Reader, prompt string) (string, bool) {
+	fmt.Print(prompt)
+	line, err := reader.ReadString('\n')
+	if err != nil && line == "" {
+		return "", false
+	}
+	return strings.TrimRight(line, "\r\n"), true
+}
+
+func readEntry(reader *bufio.Reader) (string, bool) { // 读入一条完整的输入，可能有多行
+	line, ok := readLine(reader, "User>>")
+	if !ok {
+		return "", false
+	}
+	lines := []string{line}
+	if strings.TrimSpace(line) == "S:" { // 代码块直到 :E
+		for {
+			line, ok := readLine(reader, "    ..")
+			if !ok {
+				break
+			}
+			lines = append(lines, line)
+			if strings.TrimSpace(line) == ":E" {
+				break
+			}
+		}
+		return strings.Join(lines, "\n"), true
+	}
+	for lisp.Unclosed(strings.Join(lines, "\n")) {
+		line, ok := readLine(reader, "    ..")
+		if !ok {
+			break
+		}
+		lines = append(lines, line)
+	}
+	return strings.Join(lines, "\n"), true
+}
+
+type repl struct {
+	in      *lisp.Interpreter
+	hist    *history
+	session []string // 本次会话中成功执行的定义，:save 时写出
+}
+
+var commands = []string{ // 命令及说明
+	":load file", "执行代码文件",
+	":reset", "清空全部定义",
+	":env", "列出定义的变量和函数",
+	":type expr", "求值并显示结果的类型",
+	":time expr", "求值并显示用时",
+	":ast expr", "显示读入的语法树",
+	":debug on|off", "开关调试输出",
+	":save file", "把本次会话中的定义保存为代码文件",
+	":history", "显示最近的输入历史，!n 重新执行第n条",
+}
+
+func split(entry string) []string { // 按行分开，去掉 S: 与 :E
+	lines := strings.Split(entry, "\n")
+	if strings.TrimSpace(lines[0]) == "S:" {
+		if last := len(lines) - 1; last > 0 && strings.TrimSpace(lines[last]) == ":E" {
+			lines = lines[:last]
+		}
+		return lines[1:]
+	}
+	return lines
+}
+
+func (self *repl) eval(entry string) (lisp.Object, error) { // 单行按原来的规则执行，多行或代码块按代码块规则执行
+	if lines := split(entry); len(lines) != 1 || lines[0] != entry {
+		return self.in.EvalBlock(strings.Join(lines, "\n"))
+	}
+	return self.in.Eval(entry)
+}
+
+func (self *repl) defines(entry string) bool { // 输入中是否有顶层的定义
+	trees, err := self.in.ReadBlock(strings.Join(split(entry), "\n"))
+	if err != nil {
+		return false
+	}
+	for _, tree := range trees {
+		v, ok := tree.([]lisp.Object)
+		if !ok || len(v) == 0 {
+			continue
+		}
+		if sym, ok := v[0].(*lisp.Symbol); ok {
+			switch sym.Name {
+			case "set", "=", "def", "set!", "defmacro", "load", "import":
+				return true
+			case "fn":
+				if len(v) == 4 {
+					return true
+				}
+			case "let":
+				if len(v) > 1 {
+					if _, ok := v[1].(*lisp.Symbol); ok {
+						return true
+					}
+				}
+			}
+		}
+	}
+	return false
+}
+
+func (self *repl) show(res lisp.Object, err error) {
+	if err != nil {
+		fmt.Println(err)
+	} else {
+		fmt.Println(lisp.ToString(res, true))
+	}
+}
+
+func (self *repl) command(cmd string) { // 执行 : 开头的命令
+	name, arg := cmd, ""
+	if i := strings.IndexAny(cmd, " \t\n"); i > 0 {
+		name, arg = cmd[:i], strings.TrimSpace(cmd[i:])
+	}
+	switch name {
+	case ":load":
+		res, err := self.in.EvalFile(arg)
+		if err == nil {
+			if abs, e := filepath.Abs(arg); e == nil {
+				arg = abs
+			}
+			self.session = append(self.session, "(load "+lisp.ToString(lisp.Str(arg), true)+")")
+		}
+		self.show(res, err)
+	case ":reset":
+		self.in.Reset()
+		self.session = nil
+	case ":env":
+		for _, name := range self.in.Defined() {
+			fmt.Printf("%v = %v\n", name, lisp.ToString(self.in.Env.Get(name), true))
+		}
+	case ":type":
+		res, err := self.in.EvalBlock(arg)
+		if err != nil {
+			fmt.Println(err)
+		} else {
+			fmt.Println(lisp.TypeOf(res))
+		}
+	case ":time":
+		start := time.Now()
+		res, err := self.in.EvalBlock(arg)
+		self.show(res, err)
+		fmt.Println("用时", time.Since(start))
+	case ":ast":
+		trees, err := self.in.ReadBlock(arg)
+		if err != nil {
+			fmt.Println(err)
+		}
+		for _, tree := range trees {
+			fmt.Println(lisp.ToString(tree, true))
+		}
+	case ":debug":
+		switch arg {
+		case "on":
+			lisp.DEBUG = true
+		case "off":
+			lisp.DEBUG = false
+		default:
+			fmt.Println("用法：:debug on|off")
+		}
+	case ":save":
+		var buf strings.Builder
+		for _, entry := range self.session {
+			if lines := strings.Split(entry, "\n"); len(lines) > 1 && strings.TrimSpace(lines[0]) != "S:" {
+				entry = "S:\n" + entry + "\n:E" // 多行的定义放在代码块中
+			}
+			buf.WriteString(entry + "\n")
+		}
+		if err := ioutil.WriteFile(arg, []byte(buf.String()), 0644); err != nil {
+			fmt.Println(err)
+		}
+	case ":history":
+		self.hist.print()
+	default:
+		fmt.Println("未知命令", name)
+		for i := 0; i < len(commands); i += 2 {
+			fmt.Printf("  %-14v %v\n", commands[i], commands[i+1])
+		}
+	}
+}
+
+func ExeIDLE(in *lisp.Interpreter) { // 解释执行
+	reader := bufio.NewReader(os.Stdin)
+	self := &repl{in: in, hist: loadHistory()}
+	for {
+		entry, ok := readEntry(reader)
+		cmd := strings.TrimSpace(entry)
+		if cmd == "exit" || !ok {
+			return
+		}
+		switch {
+		case cmd == "":
+			continue
+		case strings.HasPrefix(cmd, "!"): // 重新执行历史中的第n条
+			n, err := strconv.Atoi(cmd[1:])
+			if err != nil || n < 1 || n > len(self.hist.items) {
+				fmt.Println("没有这条历史:", cmd)
+				continue
+			}
+			entry = self.hist.items[n-1]
+			cmd = strings.TrimSpace(entry)
+			fmt.Println(entry)
+		}
+		self.hist.add(entry)
+		if strings.HasPrefix(cmd, ":") && cmd != ":E" {
+			self.command(cmd)
+			continue
+		}
+		if !strings.Contains(entry, "\n") && lisp.FindExpr(entry) == "" { // 单行中没有表达式时视为注释
+			continue
+		}
+		res, err := self.eval(entry)
+		if err == nil && self.defines(entry) {
+			self.session = append(self.session, entry)
+		}
+		self.show(res, err)
+	}
+}
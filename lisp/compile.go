@@ -0,0 +1,403 @@
+package lisp
+
+import "fmt"
+
+// 字节码编译器：将语法树编译为 Proto，由 vm.go 中的虚拟机执行，语义与 Eval 相同
+// 结构错误在运行到该处时才报告，与 Eval 一致；宏在运行时展开
+
+type Op uint8 // 指令
+
+const (
+	opConst     Op = iota // 压入常量 consts[A]
+	opGet                 // 压入变量 consts[A] 的值
+	opSet                 // 以栈顶的值执行 set/=，值留在栈顶
+	opDef                 // 以栈顶的值执行 def，值留在栈顶
+	opAssign              // 以栈顶的值执行 set!，值留在栈顶
+	opPop                 // 弹出栈顶
+	opJump                // 跳转到A
+	opJumpFalse           // 弹出条件，为false时跳转到A，条件不是bool时报错，consts[B]为语句名
+	opStmt                // 语句块中的一条语句执行完毕：栈顶为Return时跳转到A，否则弹出
+	opEnter               // 进入语句块，新建一层内环境
+	opLeave               // 离开语句块
+	opGetFn               // 压入函数 consts[A]，是宏时改为对整个节点求值并跳转到B
+	opGetFnTail           // 同 opGetFn，处于尾部位置
+	opCheckFn             // 检查栈顶是否可调用
+	opCall                // 以栈顶的A个值为参数调用其下的函数
+	opTailCall            // 同 opCall，调用自定义函数时压入尾调用
+	opList                // 将栈顶的A个值组成列表
+	opRet                 // 将栈顶的值包装为Return
+	opFn                  // 由 protos[A] 创建函数，B为1时以函数名定义
+	opEval                // 对节点 nodes[sites[pc].node] 求值，用于不常用的语句
+	opError               // 报告错误 consts[A]
+	opLoop                // 循环结束：栈顶为交给标签 consts[B] 的 break 时改为nil，为 continue 时弹出并跳转到A
+	opMatch               // 栈顶的值等于 consts[B] 中任一值时弹出，否则跳转到A
+	opIter                // 将栈顶的集合或次数替换为遍历循环 consts[A] 的迭代器
+	opNext                // 栈顶迭代器结束时跳转到A，否则压入下一个元素
+	opUnpack              // 将栈顶的元素拆分为A个值，consts[B]为语句名
+	opNip                 // 弹出栈顶之下的值
+)
+
+type Instr struct { // 一条指令
+	Op Op
+	A  int32
+	B  int32
+}
+
+type site struct { // 指令出错时记录的节点 nodes[node] 及其中元素的下标，node为-1时不记录
+	node int32
+	idx  int32
+}
+
+type Proto struct { // 编译后的代码：顶层表达式或函数体
+	name   string   // 函数名
+	args   []Object // 形参
+	sig    *signature
+	body   Object // 函数体语法树
+	code   []Instr
+	sites  []site // 与code一一对应
+	consts []Object
+	nodes  [][]Object
+	protos []*Proto // 其中定义的函数
+
+	nodeIdx map[*Object]int32 // 编译时使用
+}
+
+func Compile(tree Object) *Proto { // 编译一个顶层表达式
+	p := &Proto{}
+	p.expr(tree, nil, -1)
+	p.nodeIdx = nil
+	return p
+}
+
+func (self *Proto) Run(env *EnvType) Object { // 在env中执行，结果与 Eval 相同
+	return self.run(env)
+}
+
+func (self *Proto) String() string {
+	return fmt.Sprintf("<proto %v %v>", self.name, len(self.code))
+}
+
+func (self *Proto) emit(op Op, a int32, node []Object, idx int) int { // 添加指令，返回其位置
+	self.code = append(self.code, Instr{Op: op, A: a})
+	s := site{-1, int32(idx)}
+	if len(node) > 0 {
+		s.node = self.node(node)
+	}
+	self.sites = append(self.sites, s)
+	return len(self.code) - 1
+}
+
+func (self *Proto) konst(v Object) int32 { // 添加常量，符号与字符串等重复时复用
+	if k, ok := hashKey(v); ok && v != nil {
+		for i, c := range self.consts {
+			if ck, ok := hashKey(c); ok && ck == k && c != nil && numLevel(c) == numLevel(v) { // 1 与 1.0 键相同，但不能互相替代
+				return int32(i)
+			}
+		}
+	}
+	self.consts = append(self.consts, v)
+	return int32(len(self.consts) - 1)
+}
+
+func (self *Proto) node(v []Object) int32 {
+	if self.nodeIdx == nil {
+		self.nodeIdx = make(map[*Object]int32)
+	}
+	if i, ok := self.nodeIdx[&v[0]]; ok {
+		return i
+	}
+	self.nodes = append(self.nodes, v)
+	self.nodeIdx[&v[0]] = int32(len(self.nodes) - 1)
+	return int32(len(self.nodes) - 1)
+}
+
+func (self *Proto) here() int32 { // 下一条指令的位置
+	return int32(len(self.code))
+}
+
+func (self *Proto) patch(pc int) { // 将pc处指令的跳转目标设为下一条指令
+	self.code[pc].A = self.here()
+}
+
+func (self *Proto) raise(err *LispError, node []Object, idx int) { // 编译为运行时报告的错误
+	self.emit(opError, self.konst(err), node, idx)
+}
+
+func (self *Proto) expr(e Object, parent []Object, idx int) { // 编译表达式，parent、idx为原子表达式出错时记录的位置
+	switch x := e.(type) {
+	case []Object:
+		self.list(x, false)
+	case *Symbol:
+		if x.IsKeyword() {
+			self.emit(opConst, self.konst(x), nil, -1)
+			return
+		}
+		self.emit(opGet, self.konst(x), parent, idx)
+	default:
+		self.emit(opConst, self.konst(x), nil, -1)
+	}
+}
+
+func (self *Proto) block(exprs []Object, node []Object) { // 编译语句块，语义同 evalBlock：遇到Return时停止，否则结果为nil
+	var exits []int
+	for _, e := range exprs {
+		self.expr(e, node, -1)
+		exits = append(exits, self.emit(opStmt, 0, nil, -1))
+	}
+	self.emit(opConst, self.konst(nil), nil, -1)
+	for _, pc := range exits {
+		self.patch(pc)
+	}
+}
+
+func (self *Proto) list(v []Object, tail bool) { // 编译列表表达式
+	if len(v) == 0 {
+		self.emit(opConst, self.konst([]Object{}), nil, -1)
+		return
+	}
+	sym, ok := v[0].(*Symbol)
+	if !ok { // 表达式开头，如 ((make-adder 3) 4)
+		self.expr(v[0], v, -1)
+		self.emit(opCheckFn, 0, v, 0)
+		self.call(v, tail)
+		return
+	}
+	if (sym.Name == "for-each" || sym.Name == "dotimes") && isEachForm(v) {
+		self.each(v)
+		return
+	}
+	switch op := sym.Name; op {
+	case "set", "=", "def", "set!":
+		if len(v) != 3 {
+			self.raise(NewError(SyntaxError, "%v 结构错误！正确格式为：(%v name expr)", op, op), v, -1)
+			return
+		}
+		name, ok := v[1].(*Symbol)
+		if !ok {
+			self.raise(NewError(SyntaxError, "%v 的变量名应为符号, 实际为 %v", op, v[1]), v, 1)
+			return
+		}
+		self.expr(v[2], v, -1)
+		switch op {
+		case "def":
+			self.emit(opDef, self.konst(name), nil, -1)
+		case "set!":
+			self.emit(opAssign, self.konst(name), v, 1)
+		default:
+			self.emit(opSet, self.konst(name), nil, -1)
+		}
+	case "let":
+		self.let(v)
+	case "ret":
+		switch len(v) {
+		case 1:
+			self.emit(opConst, self.konst(nil), nil, -1)
+		case 2:
+			if sub, ok := v[1].([]Object); ok {
+				self.list(sub, true) // (ret (f x)) 为尾调用
+			} else {
+				self.expr(v[1], v, -1)
+			}
+		default: // 多个返回值组成列表
+			for i := 1; i < len(v); i++ {
+				self.expr(v[i], v, i)
+			}
+			self.emit(opList, int32(len(v)-1), nil, -1)
+		}
+		self.emit(opRet, 0, nil, -1)
+	case "quote":
+		if len(v) != 2 {
+			self.raise(NewError(SyntaxError, "quote 需要1个参数"), v, -1)
+			return
+		}
+		self.emit(opConst, self.konst(v[1]), nil, -1)
+	case "quasiquote", "unquote", "splice-unquote", "defmacro", "macroexpand", "load", "import", "try", "break", "continue": // 不常用的语句交给 Eval
+		self.emit(opEval, 0, v, -1)
+	case "if":
+		self.ifs(v)
+	case "cond", "case", "switch", "when", "unless":
+		self.branches(v)
+	case "fn":
+		if len(v) == 3 {
+			self.fn("", v, 1, false)
+			return
+		}
+		if len(v) != 4 {
+			self.raise(NewError(SyntaxError, "fn 结构错误！正确格式为：(fn fn_name [x y ... ] {expr1 expr2 ...})"), v, -1)
+			return
+		}
+		name, ok := v[1].(*Symbol)
+		if !ok {
+			self.raise(NewError(SyntaxError, "fn 的函数名应为符号, 实际为 %v", v[1]), v, 1)
+			return
+		}
+		self.fn(name.Name, v, 2, true)
+	case "lambda":
+		if len(v) != 3 {
+			self.raise(NewError(SyntaxError, "lambda 结构错误！正确格式为：(lambda [x y ...] {expr1 expr2 ...})"), v, -1)
+			return
+		}
+		self.fn("", v, 1, false)
+	case "for":
+		self.loop(v)
+	default:
+		op := opGetFn
+		if tail {
+			op = opGetFnTail
+		}
+		pc := self.emit(op, self.konst(sym), v, 0)
+		self.call(v, tail)
+		self.code[pc].B = self.here()
+	}
+}
+
+func (self *Proto) call(v []Object, tail bool) { // 编译参数及调用，函数已在栈上
+	for i := 1; i < len(v); i++ {
+		self.expr(v[i], v, i)
+	}
+	op := opCall
+	if tail {
+		op = opTailCall
+	}
+	self.emit(op, int32(len(v)-1), v, -1)
+}
+
+func (self *Proto) let(v []Object) {
+	if len(v) != 3 {
+		self.raise(NewError(SyntaxError, "let 结构错误！正确格式为：(let [name1 expr1 name2 expr2 ...] {expr1 expr2 ...})"), v, -1)
+		return
+	}
+	if name, ok := v[1].(*Symbol); ok {
+		self.expr(v[2], v, -1)
+		self.emit(opDef, self.konst(name), nil, -1)
+		return
+	}
+	binds, ok := v[1].([]Object)
+	if !ok || len(binds)%2 != 0 {
+		self.raise(NewError(SyntaxError, "let 的绑定应为 [name1 expr1 name2 expr2 ...]"), v, 1)
+		return
+	}
+	exprs, ok := v[2].([]Object)
+	if !ok {
+		self.raise(NewError(SyntaxError, "let 的语句块应为列表 {expr1 expr2 ...}"), v, 2)
+		return
+	}
+	self.emit(opEnter, 0, nil, -1)
+	for i := 0; i < len(binds); i += 2 {
+		name, ok := binds[i].(*Symbol)
+		if !ok {
+			self.raise(NewError(SyntaxError, "let 的变量名应为符号, 实际为 %v", binds[i]), v, 1)
+			return
+		}
+		self.expr(binds[i+1], v, -1)
+		self.emit(opDef, self.konst(name), nil, -1)
+		self.emit(opPop, 0, nil, -1)
+	}
+	if len(exprs) == 0 {
+		self.emit(opConst, self.konst(nil), nil, -1)
+	}
+	var exits []int
+	for i, e := range exprs { // 结果为最后一个表达式的值
+		self.expr(e, v, -1)
+		if i < len(exprs)-1 {
+			exits = append(exits, self.emit(opStmt, 0, nil, -1))
+		}
+	}
+	for _, pc := range exits {
+		self.patch(pc)
+	}
+	self.emit(opLeave, 0, nil, -1)
+}
+
+func (self *Proto) ifs(v []Object) {
+	syntax := NewError(SyntaxError, "if 结构错误！正确格式为：(if (bool expr) {expr1 expr2 ...} {expr3 expr4 ...})")
+	if len(v) != 3 && len(v) != 4 {
+		self.raise(syntax, v, -1)
+		return
+	}
+	self.expr(v[1], v, -1)
+	else_pc := self.emit(opJumpFalse, 0, v, 1)
+	self.code[else_pc].B = self.konst(Str("if"))
+	self.branch(v, 2, syntax)
+	end_pc := self.emit(opJump, 0, nil, -1)
+	self.patch(else_pc)
+	if len(v) == 4 {
+		self.branch(v, 3, syntax)
+	} else {
+		self.emit(opConst, self.konst(nil), nil, -1)
+	}
+	self.patch(end_pc)
+}
+
+func (self *Proto) branch(v []Object, i int, syntax *LispError) { // if 的一个分支
+	exprs, ok := v[i].([]Object)
+	if !ok {
+		self.raise(syntax, v, i)
+		return
+	}
+	self.emit(opEnter, 0, nil, -1)
+	self.block(exprs, v)
+	self.emit(opLeave, 0, nil, -1)
+}
+
+func (self *Proto) loop(v []Object) {
+	label, c, err := loopLabel(v)
+	if err != nil {
+		self.raise(err, v, err.idx)
+		return
+	}
+	exprs, ok := v[c+1].([]Object)
+	if !ok {
+		self.raise(NewError(SyntaxError, "for 的循环体应为列表 {expr1 expr2 ...}"), v, c+1)
+		return
+	}
+	self.emit(opEnter, 0, nil, -1)
+	start := self.here()
+	self.expr(v[c], v, -1)
+	end_pc := self.emit(opJumpFalse, 0, v, c)
+	self.code[end_pc].B = self.konst(Str("for"))
+	var exits []int
+	for _, e := range exprs { // 循环体中遇到Return时结束循环
+		self.expr(e, v, -1)
+		exits = append(exits, self.emit(opStmt, 0, nil, -1))
+	}
+	self.emit(opJump, start, nil, -1)
+	self.patch(end_pc)
+	self.emit(opConst, self.konst(nil), nil, -1)
+	for _, pc := range exits {
+		self.patch(pc)
+	}
+	pc := self.emit(opLoop, int32(start), nil, -1)
+	self.code[pc].B = self.konst(Str(label))
+	self.emit(opLeave, 0, nil, -1)
+}
+
+func (self *Proto) fn(name string, v []Object, i int, define bool) { // 编译函数定义，检查与 makeFn 相同
+	desc := name
+	if desc == "" {
+		desc = "lambda"
+	}
+	args, ok := v[i].([]Object)
+	if !ok {
+		self.raise(NewError(SyntaxError, "%v 的形参应为列表 [x y ...]", desc), v, i)
+		return
+	}
+	sig, err := parseParams(desc, args)
+	if err != nil {
+		self.raise(err, v, i)
+		return
+	}
+	body, ok := v[i+1].([]Object)
+	if !ok {
+		self.raise(NewError(SyntaxError, "%v 的函数体应为列表 {expr1 expr2 ...}", desc), v, i+1)
+		return
+	}
+	p := &Proto{name: name, args: args, sig: sig, body: body}
+	p.block(body, nil)
+	p.nodeIdx = nil
+	self.protos = append(self.protos, p)
+	pc := self.emit(opFn, int32(len(self.protos)-1), nil, -1)
+	if define {
+		self.code[pc].B = 1
+	}
+}
@@ -0,0 +1,233 @@
+package lisp
+
+// 多分支判断：(cond (bool expr) {...} (bool expr) {...} else {...})、(case expr v1 {...} (v2 v3) {...} else {...})
+// (when (bool expr) {...})、(unless (bool expr) {...})；switch 同 case。与 if 相同，分支为语句块，结果为nil，ret 向外传递
+
+type clause struct {
+	test Object   // cond 的判断条件，case 的候选值列表
+	idx  int      // test 在节点中的下标
+	body []Object // 语句块
+	els  bool     // 是否为 else 分支
+}
+
+type branchForm struct {
+	op      string
+	match   bool // case/switch：以 v[1] 的值与候选值比较
+	negate  bool // unless：条件为false时执行
+	clauses []clause
+}
+
+func parseBranch(v []Object) (*branchForm, *LispError) { // 检查多分支判断的结构
+	f := &branchForm{op: v[0].(*Symbol).Name}
+	var form string
+	start := 1
+	switch f.op {
+	case "cond":
+		form = "(cond (bool expr) {expr1 expr2 ...} ... else {expr1 expr2 ...})"
+	case "case", "switch":
+		form = "(" + f.op + " expr v1 {expr1 expr2 ...} (v2 v3) {expr1 expr2 ...} ... else {expr1 expr2 ...})"
+		f.match, start = true, 2
+	default:
+		form = "(" + f.op + " (bool expr) {expr1 expr2 ...})"
+		f.negate = f.op == "unless"
+	}
+	syntax := func(i int) *LispError {
+		err := NewError(SyntaxError, "%v 结构错误！正确格式为：%v", f.op, form)
+		err.idx = i
+		return err
+	}
+	n := len(v) - start
+	if n < 2 || n%2 != 0 || (f.op == "when" || f.op == "unless") && n != 2 {
+		return nil, syntax(-1)
+	}
+	for i := start; i < len(v); i += 2 {
+		c := clause{test: v[i], idx: i}
+		if sym, ok := v[i].(*Symbol); ok && sym.Name == "else" && f.op != "when" && f.op != "unless" {
+			if i+2 != len(v) { // else 只能是最后一个分支
+				return nil, syntax(i)
+			}
+			c.els = true
+		} else if f.match { // 候选值不求值，列表表示其中任一值
+			alts, ok := v[i].([]Object)
+			if !ok {
+				alts = []Object{v[i]}
+			}
+			c.test = candidates(alts)
+		}
+		var ok bool
+		if c.body, ok = v[i+1].([]Object); !ok {
+			return nil, syntax(i + 1)
+		}
+		f.clauses = append(f.clauses, c)
+	}
+	return f, nil
+}
+
+func candidates(alts []Object) []Object { // 候选值中的符号 nil 表示空值，true、false 读入时已是bool
+	res := make([]Object, len(alts))
+	for i, alt := range alts {
+		res[i] = alt
+		if sym, ok := alt.(*Symbol); ok && sym.Name == "nil" {
+			res[i] = nil
+		}
+	}
+	return res
+}
+
+func matches(x Object, alts []Object) bool { // x是否等于候选值之一
+	for _, alt := range alts {
+		if Equal(x, alt) {
+			return true
+		}
+	}
+	return false
+}
+
+func (self *branchForm) check(c *clause, t Object, v []Object) (bool, *LispError) { // 判断条件的值是否选中该分支
+	du, ok := t.(bool)
+	if !ok {
+		return false, NewError(TypeError, "%v 的判断条件应为bool, 实际为 %v", self.op, t).at(v, c.idx)
+	}
+	return du != self.negate, nil
+}
+
+func evalBranch(v []Object, env *EnvType) Object {
+	f, err := parseBranch(v)
+	if err != nil {
+		return err
+	}
+	var x Object
+	if f.match {
+		if x = Eval(v[1], env); IsError(x) {
+			return x
+		}
+	}
+	for i := range f.clauses {
+		c := &f.clauses[i]
+		if !c.els {
+			var ok bool
+			if f.match {
+				ok = matches(x, c.test.([]Object))
+			} else {
+				t := Eval(c.test, env)
+				if IsError(t) {
+					return t
+				}
+				if ok, err = f.check(c, t, v); err != nil {
+					return err
+				}
+			}
+			if !ok {
+				continue
+			}
+		}
+		return evalBlock(c.body, env.Copy())
+	}
+	return nil
+}
+
+func (self *closureCompiler) branch(v []Object, sc *scope) closure {
+	f, err := parseBranch(v)
+	if err != nil {
+		return func(*EnvType) Object {
+			_, err := parseBranch(v) // 每次报告新的错误值
+			return err
+		}
+	}
+	var subject closure
+	if f.match {
+		subject = self.expr(v[1], sc)
+	}
+	bs := &scope{up: sc} // 只执行其中一个分支，共用一层
+	tests, bodies := make([]closure, len(f.clauses)), make([]closure, len(f.clauses))
+	for i, c := range f.clauses {
+		if !c.els && !f.match {
+			tests[i] = self.expr(c.test, sc)
+		}
+		bodies[i] = self.block(c.body, bs)
+	}
+	return func(env *EnvType) Object {
+		var x Object
+		if subject != nil {
+			if x = subject(env); IsError(x) {
+				return x
+			}
+		}
+		for i := range f.clauses {
+			c := &f.clauses[i]
+			if !c.els {
+				var ok bool
+				if f.match {
+					ok = matches(x, c.test.([]Object))
+				} else {
+					t := tests[i](env)
+					if IsError(t) {
+						return t
+					}
+					var err *LispError
+					if ok, err = f.check(c, t, v); err != nil {
+						return err
+					}
+				}
+				if !ok {
+					continue
+				}
+			}
+			return bodies[i](env.block(bs))
+		}
+		return nil
+	}
+}
+
+func (self *Proto) branches(v []Object) {
+	f, err := parseBranch(v)
+	if err != nil {
+		self.raise(err, v, err.idx)
+		return
+	}
+	if f.match {
+		self.expr(v[1], v, -1)
+	}
+	var ends []int
+	for _, c := range f.clauses {
+		if c.els {
+			if f.match {
+				self.emit(opPop, 0, nil, -1)
+			}
+			self.branch(v, c.idx+1, nil)
+			self.patchAll(ends)
+			return
+		}
+		var next int
+		if f.match { // 相等时弹出比较的值，否则跳到下一个分支
+			next = self.emit(opMatch, 0, nil, -1)
+			self.code[next].B = self.konst(c.test)
+		} else {
+			self.expr(c.test, v, -1)
+			next = self.emit(opJumpFalse, 0, v, c.idx)
+			self.code[next].B = self.konst(Str(f.op))
+		}
+		if f.negate { // unless：条件为true时结果为nil
+			self.emit(opConst, self.konst(nil), nil, -1)
+			ends = append(ends, self.emit(opJump, 0, nil, -1))
+			self.patch(next)
+			self.branch(v, c.idx+1, nil)
+			self.patchAll(ends)
+			return
+		}
+		self.branch(v, c.idx+1, nil)
+		ends = append(ends, self.emit(opJump, 0, nil, -1))
+		self.patch(next)
+	}
+	if f.match {
+		self.emit(opPop, 0, nil, -1)
+	}
+	self.emit(opConst, self.konst(nil), nil, -1)
+	self.patchAll(ends)
+}
+
+func (self *Proto) patchAll(pcs []int) {
+	for _, pc := range pcs {
+		self.patch(pc)
+	}
+}
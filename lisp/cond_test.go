@@ -0,0 +1,18 @@
+package lisp
+
+import "testing"
+
+func TestCase(t *testing.T) {
+	kind := "(fn kind [x] {(case x nil {(ret \"nil\")} true {(ret \"true\")} (false 0) {(ret \"false\")} a {(ret \"a\")} else {(ret \"other\")})}) "
+	expect(t, [][2]string{
+		{kind + "(kind nil)", `"nil"`},
+		{kind + "(kind (get %{} 1))", `"nil"`},
+		{kind + "(kind (== 1 1))", `"true"`},
+		{kind + "(kind false)", `"false"`},
+		{kind + "(kind 0)", `"false"`},
+		{kind + "(kind 'a)", `"a"`},
+		{kind + "(kind 'nil)", `"other"`},
+		{kind + "(kind \"nil\")", `"other"`},
+		{"(case 2 (1 nil) {(out 1)}) (switch nil (1 nil) {(ret 2)})", "2"},
+	})
+}
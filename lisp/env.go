@@ -0,0 +1,171 @@
+package lisp
+
+import (
+	"strings"
+	"sync"
+)
+
+type Object interface{}
+
+type Symbol struct { // 符号，同名符号只有一个实例
+	Name string
+}
+
+func (self *Symbol) String() string {
+	return self.Name
+}
+
+var symbols = make(map[string]*Symbol) // 符号表
+var symbolsLock sync.Mutex
+
+func Sym(name string) *Symbol { // 取得名为name的符号
+	symbolsLock.Lock()
+	defer symbolsLock.Unlock()
+	s, ok := symbols[name]
+	if !ok {
+		s = &Symbol{name}
+		symbols[name] = s
+	}
+	return s
+}
+
+func (self *Symbol) IsKeyword() bool { // 以 : 开头的符号是关键字，求值为自身，如命名参数 :sep
+	return len(self.Name) > 1 && self.Name[0] == ':'
+}
+
+type EnvType struct { // 环境中的一层，由内向外链接：全局、函数帧、if/for等语句块帧
+	vars  map[string]Object // 按名保存的变量，首次定义时创建
+	slots []Object          // 编译时确定了槽位的变量，槽位由 scope 给出
+	scope *scope
+	up    *EnvType // 外层，全局环境为nil
+	fn    *EnvType // 所在的函数帧，全局及其中的语句块为全局环境
+
+	in *Interpreter // 所属解释器，可为nil
+}
+
+type unsetType struct{}
+
+var unset Object = unsetType{} // 槽位中尚未定义的变量
+
+func NewEnv(global map[string]Object) *EnvType { // 以global为最外层创建环境
+	env := &EnvType{vars: global}
+	env.fn = env
+	return env
+}
+
+func (self *EnvType) Strict() bool { // 是否为严格模式：未定义的符号报错
+	return self.in != nil && self.in.Strict
+}
+
+func (self *EnvType) Copy() *EnvType { // 新建一层内环境，用于语句块
+	return &EnvType{up: self, fn: self.fn, in: self.in}
+}
+func (self *EnvType) FnCopy() *EnvType { // 新建一层函数帧，用于函数调用
+	env := &EnvType{up: self, in: self.in}
+	env.fn = env
+	return env
+}
+func (self *EnvType) local(key string) (Object, bool) { // 只在本层查找
+	if self.scope != nil {
+		if i, ok := self.scope.names[key]; ok {
+			v := self.slots[i]
+			return v, v != unset
+		}
+	}
+	v, ok := self.vars[key]
+	return v, ok
+}
+func (self *EnvType) put(key string, val Object) { // 在本层定义，有槽位时存入槽位
+	if self.scope != nil {
+		if i, ok := self.scope.names[key]; ok {
+			self.slots[i] = val
+			return
+		}
+	}
+	if self.vars == nil {
+		self.vars = make(map[string]Object)
+	}
+	self.vars[key] = val
+}
+func (self *EnvType) Def(key string, val Object) { // 在内环境定义key-val，同名时遮蔽外环境
+	self.put(key, val)
+}
+func (self *EnvType) Set(key string, val Object) { // 设置key-val，只在当前函数内查找，不会修改外层函数或全局的同名变量
+	for e := self; e != self.fn; e = e.up { // 从内环境向外查找
+		if _, ok := e.local(key); ok {
+			e.put(key, val)
+			return
+		}
+	}
+	// 找不到就在函数帧设置
+	self.fn.put(key, val)
+}
+func (self *EnvType) Assign(key string, val Object) bool { // 修改已有的key-val，可修改外层，不存在时返回false
+	for e := self; e != nil; e = e.up { // 从内环境向外查找
+		if _, ok := e.local(key); ok {
+			e.put(key, val)
+			return true
+		}
+	}
+	return false
+}
+func (self *EnvType) Get(key string) Object { // 获取value
+	v, _ := self.Lookup(key)
+	return v
+}
+func (self *EnvType) Find(key string) bool { // 判断key是否存在
+	_, ok := self.Lookup(key)
+	return ok
+}
+func (self *EnvType) Lookup(key string) (Object, bool) { // 查找key，ns/name 形式在导入的模块ns中查找name
+	for e := self; e != nil; e = e.up { // 从内环境向外查找
+		if v, ok := e.local(key); ok {
+			return v, true
+		}
+	}
+	if i := strings.Index(key, "/"); i > 0 && i < len(key)-1 {
+		if m, ok := self.Get(key[:i]).(*Module); ok {
+			return m.Env.Lookup(key[i+1:])
+		}
+	}
+	return nil, false
+}
+
+type Fn struct { // 函数结构
+	Name  string   // 函数名，匿名函数为空
+	Args  []Object // 形参
+	Body  Object   // 函数体
+	Env   *EnvType // 定义时的环境
+	Macro bool     // 是否为宏
+
+	sig   *signature            // 解析后的形参
+	run   func(*EnvType) Object // 编译后的函数体，为nil时遍历语法树执行
+	scope *scope                // 闭包编译时函数帧的槽位
+}
+
+func (self Fn) String() string {
+	switch {
+	case self.Macro:
+		return "<macro " + self.Name + ">"
+	case self.Name == "":
+		return "<lambda>"
+	}
+	return "<fn " + self.Name + ">"
+}
+
+type Str string // 字符串，求值时不在环境中查找
+
+type Return struct { // 返回结构
+	Val Object // 结果
+}
+
+type loopCtl struct { // break/continue，以Return{*loopCtl}沿语句块返回，由对应的循环处理
+	brk   bool     // true为break，false为continue
+	label string   // 循环标签，为空时对应最内层的循环
+	node  []Object // (break ...) 节点，用于报告位置
+}
+
+type tailCall struct { // 尾调用，由CallFn循环执行
+	fn   Fn
+	args []Object
+}
@@ -0,0 +1,131 @@
+package lisp
+
+import "fmt"
+
+type ErrKind int // 错误类别
+
+const (
+	TypeError     ErrKind = iota // 类型错误
+	ArityError                   // 参数个数错误
+	UnboundSymbol                // 未定义的符号
+	SyntaxError                  // 语法错误
+	ArithError                   // 算术错误，如除数为0
+	IndexError                   // 下标越界
+	ImportError                  // 导入错误，如文件不存在、循环导入
+	GoError                      // 注册的Go函数返回的错误
+	UserError                    // throw 抛出的值
+)
+
+func (self ErrKind) String() string {
+	switch self {
+	case TypeError:
+		return "类型错误"
+	case ArityError:
+		return "参数个数错误"
+	case UnboundSymbol:
+		return "未定义符号"
+	case SyntaxError:
+		return "语法错误"
+	case ArithError:
+		return "算术错误"
+	case IndexError:
+		return "下标错误"
+	case ImportError:
+		return "导入错误"
+	case GoError:
+		return "Go函数错误"
+	case UserError:
+		return "用户错误"
+	}
+	return "错误"
+}
+
+type LispError struct { // 错误值，与Return一样沿Eval逐层返回
+	Kind ErrKind
+	Msg  string
+	Pos  Pos    // 出错位置，Line为0表示未知
+	Val  Object // throw 抛出的值
+
+	trace []errNode // 出错时经过的列表节点，由内向外，用于查找位置
+	idx   int       // 最内层节点中出错元素的下标，-1表示整个节点
+}
+
+type errNode struct {
+	node []Object
+	idx  int
+}
+
+func (self *LispError) Error() string {
+	if self.Pos.Line > 0 {
+		return fmt.Sprintf("%v: %v: %v", self.Pos, self.Kind, self.Msg)
+	}
+	return fmt.Sprintf("%v: %v", self.Kind, self.Msg)
+}
+
+func NewError(kind ErrKind, format string, a ...interface{}) *LispError { // 创建错误值
+	return &LispError{Kind: kind, Msg: fmt.Sprintf(format, a...), idx: -1}
+}
+
+func (self *LispError) setPos(pos Pos) *LispError { // 设置出错位置
+	self.Pos = pos
+	return self
+}
+
+func (self *LispError) at(node []Object, idx int) *LispError { // 记录出错节点，由内向外逐层记录
+	if self.Pos.Line == 0 {
+		if len(self.trace) == 0 && self.idx >= 0 {
+			idx = self.idx
+		}
+		self.trace = append(self.trace, errNode{node, idx})
+	}
+	return self
+}
+
+func IsError(v Object) bool { // 判断是否为错误值
+	_, ok := v.(*LispError)
+	return ok
+}
+
+func checkArity(name string, v []Object, min, max int) *LispError { // 检查参数个数，max<0表示不限
+	if len(v) < min || (max >= 0 && len(v) > max) {
+		switch {
+		case min == max:
+			return NewError(ArityError, "%v 需要%v个参数, 实际为%v个", name, min, len(v))
+		case max < 0:
+			return NewError(ArityError, "%v 至少需要%v个参数, 实际为%v个", name, min, len(v))
+		}
+		return NewError(ArityError, "%v 需要%v到%v个参数, 实际为%v个", name, min, max, len(v))
+	}
+	return nil
+}
+
+func numArgs(name string, v []Object, min, max int) ([]Object, *LispError) { // 检查数字参数
+	if err := checkArity(name, v, min, max); err != nil {
+		return nil, err
+	}
+	for i, a := range v {
+		if !IsNumber(a) {
+			err := NewError(TypeError, "%v 的第%v个参数应为数字, 实际为 %v", name, i+1, a)
+			err.idx = i + 1 // 指向调用中的第i+1个元素
+			return nil, err
+		}
+	}
+	return v, nil
+}
+
+func boolArgs(name string, v []Object, min, max int) ([]bool, *LispError) { // 检查并取出bool参数
+	if err := checkArity(name, v, min, max); err != nil {
+		return nil, err
+	}
+	bs := make([]bool, len(v))
+	for i, a := range v {
+		b, ok := a.(bool)
+		if !ok {
+			err := NewError(TypeError, "%v 的第%v个参数应为bool, 实际为 %v", name, i+1, a)
+			err.idx = i + 1
+			return nil, err
+		}
+		bs[i] = b
+	}
+	return bs, nil
+}
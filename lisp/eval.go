@@ -0,0 +1,540 @@
+package lisp
+
+import "fmt"
+
+var DEBUG = false
+
+func Debug(v ...interface{}) {
+	if DEBUG {
+		fmt.Println(v...)
+	}
+}
+
+/**计算开始**/
+func Apply(v []Object, env *EnvType) ([]Object, *LispError) { // 对调用v (op args...) 的每个参数求值，每项只求值一次
+	var res []Object
+	for i := 1; i < len(v); i++ {
+		val := Eval(v[i], env)
+		if err, ok := val.(*LispError); ok {
+			return nil, err.at(v, i)
+		}
+		res = append(res, val)
+	}
+	return res, nil
+}
+func evalBlock(exprs []Object, env *EnvType) Object { // 依次执行语句块，遇到返回或错误时立即返回
+	for _, expr := range exprs {
+		res := Eval(expr, env)
+		switch res.(type) {
+		case Return, *LispError:
+			return res
+		}
+	}
+	return nil
+}
+func Eval(tree Object, env *EnvType) Object { // 计算表达式
+	/**
+	  tree token对象
+	  env 环境（变量、函数）
+	*/
+	Debug("AST:", tree)
+	switch tree.(type) {
+	case []Object:
+		v, _ := tree.([]Object)
+		res := evalList(v, env, false)
+		if err, ok := res.(*LispError); ok {
+			err.at(v, -1) // 记录出错节点
+		}
+		return res
+
+	case *Symbol:
+		sym := tree.(*Symbol)
+		if sym.IsKeyword() {
+			return sym
+		}
+		if env.Find(sym.Name) {
+			//存在变量
+			return env.Get(sym.Name)
+		}
+		if env.Strict() {
+			return NewError(UnboundSymbol, "未定义的变量 %v", sym)
+		}
+		return sym // 非严格模式下未定义的符号求值为自身
+
+	case Object:
+		return tree
+	}
+	return nil
+}
+func evalTail(tree Object, env *EnvType) Object { // 在尾部位置求值，调用自定义函数时返回*tailCall，由CallFn循环执行
+	v, ok := tree.([]Object)
+	if !ok {
+		return Eval(tree, env)
+	}
+	Debug("AST:", tree)
+	res := evalList(v, env, true)
+	if err, ok := res.(*LispError); ok {
+		err.at(v, -1)
+	}
+	return res
+}
+func evalList(v []Object, env *EnvType, tail bool) Object { // 计算列表表达式，tail表示处于尾部位置
+	// fmt.Println("switch:", len(v))
+	if len(v) == 0 { // 空列表
+		return []Object{}
+	}
+	// 取出操作符及其对应的函数
+	sym, ok := v[0].(*Symbol)
+	if !ok {
+		f := Eval(v[0], env) // 表达式开头，如 ((make-adder 3) 4)
+		if IsError(f) {
+			return f
+		}
+		if !IsFunc(f) { // 列表数据请用 (list ...) 或 '(...)
+			return NewError(TypeError, "%v 不是函数", ToString(f, true)).at(v, 0)
+		}
+		args, err := Apply(v, env)
+		if err != nil {
+			return err
+		}
+		if fc, ok := f.(Fn); ok && tail {
+			return &tailCall{fc, args}
+		}
+		return Invoke(f, args)
+	}
+	op := sym.Name
+	// fmt.Println("op:", op)
+	if (op == "for-each" || op == "dotimes") && isEachForm(v) { // 遍历循环 (for-each [x coll] {...})、(dotimes [i n] {...})，见 loops.go
+		return evalEach(v, env)
+	}
+	switch op {
+	case "set", "=", "def", "set!": // 设置变量值(set a 12)或者(set f (+ 1 2))
+		if len(v) != 3 {
+			return NewError(SyntaxError, "%v 结构错误！正确格式为：(%v name expr)", op, op)
+		}
+		name, ok := v[1].(*Symbol)
+		if !ok {
+			return NewError(SyntaxError, "%v 的变量名应为符号, 实际为 %v", op, v[1]).at(v, 1)
+		}
+		val := Eval(v[2], env)
+		if IsError(val) {
+			return val
+		}
+		switch op {
+		case "def": // 总是在内环境定义
+			env.Def(name.Name, val)
+		case "set!": // 只修改已有的变量，可以是外层函数或全局的变量
+			if !env.Assign(name.Name, val) {
+				return NewError(UnboundSymbol, "set! 的变量 %v 未定义", name).at(v, 1)
+			}
+		default: // 当前函数内有则修改，否则在函数帧定义
+			env.Set(name.Name, val)
+		}
+		return val
+	case "let": // (let name expr) 同 def；(let [a 1 b 2] {expr1 expr2 ...}) 在新的内环境中绑定并执行，返回最后一个表达式的值
+		if len(v) != 3 {
+			return NewError(SyntaxError, "let 结构错误！正确格式为：(let [name1 expr1 name2 expr2 ...] {expr1 expr2 ...})")
+		}
+		if name, ok := v[1].(*Symbol); ok {
+			val := Eval(v[2], env)
+			if !IsError(val) {
+				env.Def(name.Name, val)
+			}
+			return val
+		}
+		binds, ok := v[1].([]Object)
+		if !ok || len(binds)%2 != 0 {
+			return NewError(SyntaxError, "let 的绑定应为 [name1 expr1 name2 expr2 ...]").at(v, 1)
+		}
+		exprs, ok := v[2].([]Object)
+		if !ok {
+			return NewError(SyntaxError, "let 的语句块应为列表 {expr1 expr2 ...}").at(v, 2)
+		}
+		let_env := env.Copy()
+		for i := 0; i < len(binds); i += 2 {
+			name, ok := binds[i].(*Symbol)
+			if !ok {
+				return NewError(SyntaxError, "let 的变量名应为符号, 实际为 %v", binds[i]).at(v, 1)
+			}
+			val := Eval(binds[i+1], let_env)
+			if IsError(val) {
+				return val
+			}
+			let_env.Def(name.Name, val)
+		}
+		return evalBody(exprs, let_env)
+	case "ret": // 返回语句 (ret expr) 或 (ret expr1 expr2 ...)，(ret (f x)) 为尾调用
+		if len(v) == 2 {
+			res := evalTail(v[1], env)
+			if IsError(res) {
+				return res
+			}
+			return Return{res}
+		}
+		if len(v) == 1 {
+			return Return{nil}
+		}
+		args, err := Apply(v, env)
+		if err != nil {
+			return err
+		}
+		return Return{args} // 多个返回值组成列表
+	case "quote": // 引用 (quote expr) 或 'expr，返回expr本身而不求值
+		if len(v) != 2 {
+			return NewError(SyntaxError, "quote 需要1个参数")
+		}
+		return v[1]
+	case "quasiquote": // 准引用 `expr，其中 ~x 求值后代入，~@x 求值后展开代入
+		if len(v) != 2 {
+			return NewError(SyntaxError, "quasiquote 需要1个参数")
+		}
+		return quasiquote(v[1], env)
+	case "unquote", "splice-unquote":
+		return NewError(SyntaxError, "%v 只能在 quasiquote 中使用", op)
+	case "defmacro": // 宏定义 (defmacro name [x y ...] {expr1 expr2 ...})，参数不求值，返回的代码在调用处求值
+		if len(v) != 4 {
+			return NewError(SyntaxError, "defmacro 结构错误！正确格式为：(defmacro name [x y ...] {expr1 expr2 ...})")
+		}
+		name, ok := v[1].(*Symbol)
+		if !ok {
+			return NewError(SyntaxError, "defmacro 的宏名应为符号, 实际为 %v", v[1]).at(v, 1)
+		}
+		res := makeFn(name.Name, v, 2, env)
+		if fn, ok := res.(Fn); ok {
+			fn.Macro = true
+			env.Set(fn.Name, fn)
+			return fn
+		}
+		return res
+	case "macroexpand": // (macroexpand 'expr) 返回宏展开后的代码
+		if len(v) != 2 {
+			return NewError(SyntaxError, "macroexpand 需要1个参数")
+		}
+		code := Eval(v[1], env)
+		if IsError(code) {
+			return code
+		}
+		return MacroExpand(code, env)
+	case "if": // 判断语句(if (bool expr) {expr1 expr2 ...} {expr3 expr4 ...})
+		if len(v) != 3 && len(v) != 4 {
+			return NewError(SyntaxError, "if 结构错误！正确格式为：(if (bool expr) {expr1 expr2 ...} {expr3 expr4 ...})")
+		}
+		cond := Eval(v[1], env)
+		if IsError(cond) {
+			return cond
+		}
+		du, ok := cond.(bool)
+		if !ok {
+			return NewError(TypeError, "if 的判断条件应为bool, 实际为 %v", cond).at(v, 1)
+		}
+		if_env := env.Copy()
+		if du {
+			exprs, ok := v[2].([]Object)
+			if !ok { // 暂不处理单个元素
+				return NewError(SyntaxError, "if 结构错误！正确格式为：(if (bool expr) {expr1 expr2 ...} {expr3 expr4 ...})").at(v, 2)
+			}
+			return evalBlock(exprs, if_env)
+		} else if len(v) == 4 { // 有else时
+			exprs, ok := v[3].([]Object)
+			if !ok {
+				return NewError(SyntaxError, "if 结构错误！正确格式为：(if (bool expr) {expr1 expr2 ...} {expr3 expr4 ...})").at(v, 3)
+			}
+			return evalBlock(exprs, if_env)
+		}
+	case "fn": // 函数定义 (fn fn_name [x y ... ] {expr1 expr2 ...})
+		if len(v) == 3 { // 匿名函数 (fn [x y ...] {expr1 expr2 ...})
+			return makeFn("", v, 1, env)
+		}
+		if len(v) != 4 {
+			return NewError(SyntaxError, "fn 结构错误！正确格式为：(fn fn_name [x y ... ] {expr1 expr2 ...})")
+		}
+		name, ok := v[1].(*Symbol)
+		if !ok {
+			return NewError(SyntaxError, "fn 的函数名应为符号, 实际为 %v", v[1]).at(v, 1)
+		}
+		res := makeFn(name.Name, v, 2, env)
+		if fn, ok := res.(Fn); ok {
+			env.Set(fn.Name, fn) // 函数捕获定义它的环境，递归时在该环境中就能找到自己
+		}
+		return res
+	case "lambda": // 匿名函数 (lambda [x y ...] {expr1 expr2 ...})，捕获定义时的环境
+		if len(v) != 3 {
+			return NewError(SyntaxError, "lambda 结构错误！正确格式为：(lambda [x y ...] {expr1 expr2 ...})")
+		}
+		return makeFn("", v, 1, env)
+	case "cond", "case", "switch", "when", "unless": // 多分支判断 (cond (bool expr) {...} ... else {...})，见 cond.go
+		return evalBranch(v, env)
+	case "for": // 循环语句(for (bool expr) {(expr1) (expr2) (expr3) ...})，带标签时为 (for label (bool expr) {...})
+		label, c, err := loopLabel(v)
+		if err != nil {
+			return err
+		}
+		exprs, ok := v[c+1].([]Object) // 循环体
+		if !ok {
+			return NewError(SyntaxError, "for 的循环体应为列表 {expr1 expr2 ...}").at(v, c+1)
+		}
+		for_env := env.Copy()
+		for {
+			cond := Eval(v[c], for_env) // v[c] 是循环判断结构
+			if IsError(cond) {
+				return cond
+			}
+			du, ok := cond.(bool)
+			if !ok {
+				return NewError(TypeError, "for 的判断条件应为bool, 实际为 %v", cond).at(v, c)
+			}
+			if !du {
+				break
+			}
+			if res := evalBlock(exprs, for_env); res != nil { // 执行循环体
+				if ctl, ok := loopSignal(res, label); ok {
+					if ctl.brk {
+						break
+					}
+					continue
+				}
+				return res
+			}
+		}
+	case "break", "continue": // 结束循环 (break) 或进入下一次循环 (continue)，(break label) 对应标签为label的外层循环
+		ctl, err := parseLoopCtl(v)
+		if err != nil {
+			return err
+		}
+		return Return{ctl}
+	case "load", "import": // (load "path") 在当前环境中执行文件；(import "path" as name) 导入模块，以 name/x 访问
+		return evalLoad(v, env)
+	case "try": // 异常处理 (try {expr1 expr2 ...} (catch e {expr1 expr2 ...}) (finally {expr1 expr2 ...}))
+		return evalTry(v, env)
+	default:
+		if !env.Find(op) {
+			return NewError(UnboundSymbol, "未定义的函数 %v", op).at(v, 0)
+		}
+		f := env.Get(op)
+		if fc, ok := f.(Fn); ok && fc.Macro { // 先展开宏，再求值展开后的代码
+			code := MacroExpand(v, env)
+			if IsError(code) || !tail {
+				return Eval(code, env)
+			}
+			return evalTail(code, env)
+		}
+		if !IsFunc(f) {
+			return NewError(TypeError, "%v 不是函数", op).at(v, 0)
+		}
+		// 取传入函数的参数(可能是表达式)
+		args, err := Apply(v, env)
+		if err != nil {
+			return err
+		}
+		if fc, ok := f.(Fn); ok && tail { // 尾调用，交给CallFn循环执行
+			return &tailCall{fc, args}
+		}
+		return Invoke(f, args)
+	}
+	return nil
+}
+
+func loopLabel(v []Object) (string, int, *LispError) { // 取出 for 的标签，返回标签及判断条件的下标
+	switch len(v) {
+	case 3:
+		return "", 1, nil
+	case 4:
+		sym, ok := v[1].(*Symbol)
+		if !ok {
+			err := NewError(SyntaxError, "%v 的标签应为符号, 实际为 %v", v[0], v[1])
+			err.idx = 1
+			return "", 0, err
+		}
+		return sym.Name, 2, nil
+	}
+	return "", 0, NewError(SyntaxError, "for 结构错误！正确格式为：(for (bool expr) {expr1 expr2 ...})")
+}
+
+func parseLoopCtl(v []Object) (*loopCtl, *LispError) { // 检查 (break) (break label) 等的结构
+	op := v[0].(*Symbol).Name
+	ctl := &loopCtl{brk: op == "break", node: v}
+	if len(v) == 2 {
+		if sym, ok := v[1].(*Symbol); ok {
+			ctl.label = sym.Name
+			return ctl, nil
+		}
+	}
+	if len(v) != 1 {
+		return nil, NewError(SyntaxError, "%v 结构错误！正确格式为：(%v) 或 (%v label)", op, op, op)
+	}
+	return ctl, nil
+}
+
+func loopSignal(res Object, label string) (*loopCtl, bool) { // res是否为交给标签为label的循环处理的break/continue
+	if r, ok := res.(Return); ok {
+		if ctl, ok := r.Val.(*loopCtl); ok && (ctl.label == "" || ctl.label == label) {
+			return ctl, true
+		}
+	}
+	return nil, false
+}
+
+func (self *loopCtl) err() *LispError { // 逃出函数或顶层的 break/continue
+	op := "continue"
+	if self.brk {
+		op = "break"
+	}
+	if self.label != "" {
+		return NewError(SyntaxError, "%v %v 没有对应的循环", op, self.label).at(self.node, -1)
+	}
+	return NewError(SyntaxError, "%v 只能在循环中使用", op).at(self.node, -1)
+}
+
+func makeFn(name string, v []Object, i int, env *EnvType) Object { // 由 v[i] 形参列表与 v[i+1] 函数体创建函数
+	fn := Fn{Name: name, Env: env}
+	desc := name
+	if desc == "" {
+		desc = "lambda"
+	}
+	var ok bool
+	if fn.Args, ok = v[i].([]Object); !ok {
+		return NewError(SyntaxError, "%v 的形参应为列表 [x y ...]", desc).at(v, i)
+	}
+	var err *LispError
+	if fn.sig, err = parseParams(desc, fn.Args); err != nil {
+		return err.at(v, i)
+	}
+	if fn.Body, ok = v[i+1].([]Object); !ok {
+		return NewError(SyntaxError, "%v 的函数体应为列表 {expr1 expr2 ...}", desc).at(v, i+1)
+	}
+	return fn
+}
+
+func MacroExpand(tree Object, env *EnvType) Object { // 反复展开宏，直到表达式开头不是宏
+	for {
+		v, ok := tree.([]Object)
+		if !ok || len(v) == 0 {
+			return tree
+		}
+		sym, ok := v[0].(*Symbol)
+		if !ok {
+			return tree
+		}
+		m, ok := env.Get(sym.Name).(Fn)
+		if !ok || !m.Macro {
+			return tree
+		}
+		tree = CallFn(m, v[1:]) // 以未求值的参数调用宏
+		if err, ok := tree.(*LispError); ok {
+			return err.at(v, 0)
+		}
+	}
+}
+
+func quasiquote(tree Object, env *EnvType) Object { // 处理准引用
+	v, ok := tree.([]Object)
+	if !ok || len(v) == 0 {
+		return tree
+	}
+	if sym, ok := v[0].(*Symbol); ok && sym.Name == "unquote" && len(v) == 2 {
+		return Eval(v[1], env)
+	}
+	res := make([]Object, 0, len(v))
+	for _, item := range v {
+		if sub, ok := item.([]Object); ok && len(sub) == 2 {
+			if sym, ok := sub[0].(*Symbol); ok && sym.Name == "splice-unquote" {
+				val := Eval(sub[1], env)
+				if IsError(val) {
+					return val
+				}
+				items, ok := val.([]Object)
+				if !ok && val != nil {
+					return NewError(TypeError, "~@ 的值应为列表, 实际为 %v", val).at(sub, 1)
+				}
+				res = append(res, items...)
+				continue
+			}
+		}
+		val := quasiquote(item, env)
+		if IsError(val) {
+			return val
+		}
+		res = append(res, val)
+	}
+	return res
+}
+
+func IsFunc(f Object) bool { // 判断是否可调用
+	switch f.(type) {
+	case func([]Object) Object, Fn:
+		return true
+	}
+	return false
+}
+
+func Invoke(f Object, args []Object) Object { // 以已求值的实参调用函数值
+	switch fc := f.(type) {
+	case func([]Object) Object: // 系统函数
+		return fc(args)
+	case Fn: // 自定义函数
+		return CallFn(fc, args)
+	}
+	return NewError(TypeError, "%v 不是函数", f)
+}
+
+func CallFn(fc Fn, args []Object) Object { // 以已求值的实参调用自定义函数
+	for {
+		fenv := fc.Env.FnCopy()
+		if fc.scope != nil {
+			fenv.frame(fc.scope)
+		}
+		desc := fc.Name
+		if desc == "" {
+			desc = "lambda"
+		}
+		if err := fc.sig.bind(desc, fenv, args); err != nil { // 将传递的参数加入函数环境，形参总是遮蔽外层同名变量
+			return err
+		}
+		var res Object
+		if fc.run != nil {
+			res = fc.run(fenv)
+		} else {
+			res = evalBlock(fc.Body.([]Object), fenv)
+		}
+		switch res := res.(type) {
+		case Return:
+			tc, ok := res.Val.(*tailCall)
+			if !ok {
+				if ctl, ok := res.Val.(*loopCtl); ok {
+					return ctl.err()
+				}
+				return res.Val
+			}
+			fc, args = tc.fn, tc.args // 尾调用：复用当前循环，不增加Go栈深度
+		case *LispError:
+			return res
+		default:
+			return nil
+		}
+	}
+}
+
+func resolve(res Object) Object { // 执行逃逸到顶层的尾调用
+	if r, ok := res.(Return); ok {
+		if tc, ok := r.Val.(*tailCall); ok {
+			res := CallFn(tc.fn, tc.args)
+			if IsError(res) {
+				return res
+			}
+			return Return{res}
+		}
+	}
+	return res
+}
+
+func topLevel(res Object) Object { // 顶层语句的结果：执行尾调用，循环外的 break/continue 报错
+	res = resolve(res)
+	if r, ok := res.(Return); ok {
+		if ctl, ok := r.Val.(*loopCtl); ok {
+			return ctl.err()
+		}
+	}
+	return res
+}
+
+/*计算结束*/
@@ -0,0 +1,58 @@
+package lisp
+
+import (
+	"fmt"
+	"runtime/debug"
+	"testing"
+)
+
+func TestTailCall(t *testing.T) {
+	defer debug.SetMaxStack(debug.SetMaxStack(8 << 20)) // 限制Go栈，没有尾调用优化时必然溢出
+	in := New()
+	res, err := in.Eval(`
+S:
+(fn loop [n acc] {
+    (if (== n 0) {
+        (ret acc)
+    } {
+        (ret (loop (- n 1) (+ acc 1)))
+    })
+})
+(fn even? [n] {(if (== n 0) {(ret true)}) (ret (odd? (- n 1)))})
+(fn odd? [n] {(if (== n 0) {(ret false)}) (ret (even? (- n 1)))})
+:E
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res, err = in.Call("loop", int64(1000000), int64(0)); err != nil || res != int64(1000000) {
+		t.Fatalf("(loop 1000000 0) = %v, %v", res, err)
+	}
+	if res, err = in.Eval("(even? 1000001)"); err != nil || res != false {
+		t.Fatalf("(even? 1000001) = %v, %v", res, err)
+	}
+	if res, err = in.Eval("(ret (loop 1000000 0))"); err != nil || res != (Return{int64(1000000)}) {
+		t.Fatalf("(ret (loop 1000000 0)) = %v, %v", res, err)
+	}
+}
+
+func TestNodePositions(t *testing.T) { // 位置表不随执行次数无限增长，仍在使用的函数保留位置
+	for _, engine := range []Engine{TreeWalk, Bytecode, Closure} {
+		in := New()
+		in.Engine = engine
+		if _, err := in.Eval("(fn f [x] {(ret (+ x 1))})"); err != nil {
+			t.Fatal(err)
+		}
+		for i := 0; i < 10000; i++ {
+			if _, err := in.Eval("(list (list 1) (list 2))"); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if len(in.nodes) > 3*nodesMin {
+			t.Errorf("engine %v: %v 个节点位置", engine, len(in.nodes))
+		}
+		if _, err := in.Eval(`(f "a")`); fmt.Sprint(err) != "1:20: 类型错误: + 的第1个参数应为数字, 实际为 a" {
+			t.Errorf("engine %v: %v", engine, err)
+		}
+	}
+}
@@ -0,0 +1,296 @@
+package lisp
+
+import (
+	"sort"
+	"strings"
+)
+
+func funcArg(name string, v []Object, i int) (Object, *LispError) { // 取出第i个参数作为函数，系统函数与自定义函数均可
+	if !IsFunc(v[i]) {
+		err := NewError(TypeError, "%v 的第%v个参数应为函数, 实际为 %v", name, i+1, ToString(v[i], true))
+		err.idx = i + 1
+		return nil, err
+	}
+	return v[i], nil
+}
+
+func seqArg(name string, v []Object, i int) ([]Object, *LispError) { // 取出第i个参数作为序列：列表、字符串(按字符)、集合、哈希表(键值对列表)
+	switch x := v[i].(type) {
+	case Str:
+		var res []Object
+		for _, r := range string(x) {
+			res = append(res, Str(string(r)))
+		}
+		return res, nil
+	case *Set:
+		return x.Items(), nil
+	case *HashMap:
+		res := make([]Object, x.Len())
+		for j, hk := range x.order {
+			e := x.entries[hk]
+			res[j] = []Object{e.Key, e.Val}
+		}
+		return res, nil
+	}
+	return listArg(name, v, i)
+}
+
+func predicate(name string, f Object, args ...Object) (bool, Object) { // 调用谓词函数，结果应为bool，出错时返回错误值
+	res := Invoke(f, args)
+	if IsError(res) {
+		return false, res
+	}
+	b, ok := res.(bool)
+	if !ok {
+		return false, NewError(TypeError, "%v 的函数应返回bool, 实际为 %v", name, ToString(res, true))
+	}
+	return b, nil
+}
+
+const maxRange = 10000000 // range 最多的元素个数
+
+func less(a, b Object) (bool, *LispError) { // sort 的默认比较：数字按大小，字符串按字典序
+	if IsNumber(a) && IsNumber(b) {
+		return NumCmp(a, b) < 0, nil
+	}
+	x, ok1 := a.(Str)
+	y, ok2 := b.(Str)
+	if ok1 && ok2 {
+		return strings.Compare(string(x), string(y)) < 0, nil
+	}
+	return false, NewError(TypeError, "sort 无法比较 %v 与 %v", ToString(a, true), ToString(b, true))
+}
+
+func funcBuiltins() map[string]Object { // 高阶函数，参数中的函数可为系统函数或自定义函数
+	return map[string]Object{
+		"map": func(v []Object) Object { // (map f l1 l2 ...) 依次以各列表的第i项调用f，长度取最短的列表
+			if err := checkArity("map", v, 2, -1); err != nil {
+				return err
+			}
+			f, err := funcArg("map", v, 0)
+			if err != nil {
+				return err
+			}
+			seqs := make([][]Object, len(v)-1)
+			n := -1
+			for i := 1; i < len(v); i++ {
+				if seqs[i-1], err = seqArg("map", v, i); err != nil {
+					return err
+				}
+				if n < 0 || len(seqs[i-1]) < n {
+					n = len(seqs[i-1])
+				}
+			}
+			res := make([]Object, n)
+			for i := 0; i < n; i++ {
+				args := make([]Object, len(seqs))
+				for j, seq := range seqs {
+					args[j] = seq[i]
+				}
+				if res[i] = Invoke(f, args); IsError(res[i]) {
+					return res[i]
+				}
+			}
+			return res
+		},
+		"filter": func(v []Object) Object { // (filter pred lst) 保留使pred为true的元素
+			if err := checkArity("filter", v, 2, 2); err != nil {
+				return err
+			}
+			f, err := funcArg("filter", v, 0)
+			if err != nil {
+				return err
+			}
+			seq, err := seqArg("filter", v, 1)
+			if err != nil {
+				return err
+			}
+			res := []Object{}
+			for _, x := range seq {
+				ok, e := predicate("filter", f, x)
+				if e != nil {
+					return e
+				}
+				if ok {
+					res = append(res, x)
+				}
+			}
+			return res
+		},
+		"reduce": func(v []Object) Object { // (reduce f init lst) 或 (reduce f lst)，从左到右累积
+			if err := checkArity("reduce", v, 2, 3); err != nil {
+				return err
+			}
+			f, err := funcArg("reduce", v, 0)
+			if err != nil {
+				return err
+			}
+			seq, err := seqArg("reduce", v, len(v)-1)
+			if err != nil {
+				return err
+			}
+			var acc Object
+			if len(v) == 3 {
+				acc = v[1]
+			} else {
+				if len(seq) == 0 {
+					return Invoke(f, nil) // 空列表且无初值时，以无参数调用f，如 (reduce + ()) 为0
+				}
+				acc, seq = seq[0], seq[1:]
+			}
+			for _, x := range seq {
+				if acc = Invoke(f, []Object{acc, x}); IsError(acc) {
+					return acc
+				}
+			}
+			return acc
+		},
+		"apply": func(v []Object) Object { // (apply f a b lst) 即 (f a b lst[0] lst[1] ...)
+			if err := checkArity("apply", v, 2, -1); err != nil {
+				return err
+			}
+			f, err := funcArg("apply", v, 0)
+			if err != nil {
+				return err
+			}
+			last, err := seqArg("apply", v, len(v)-1)
+			if err != nil {
+				return err
+			}
+			args := append(append([]Object{}, v[1:len(v)-1]...), last...)
+			return Invoke(f, args)
+		},
+		"for-each": func(v []Object) Object { // (for-each f lst) 对每个元素调用f，返回nil
+			if err := checkArity("for-each", v, 2, 2); err != nil {
+				return err
+			}
+			f, err := funcArg("for-each", v, 0)
+			if err != nil {
+				return err
+			}
+			seq, err := seqArg("for-each", v, 1)
+			if err != nil {
+				return err
+			}
+			for _, x := range seq {
+				if res := Invoke(f, []Object{x}); IsError(res) {
+					return res
+				}
+			}
+			return nil
+		},
+		"some": func(v []Object) Object { // (some pred lst) 是否有元素使pred为true
+			if err := checkArity("some", v, 2, 2); err != nil {
+				return err
+			}
+			f, err := funcArg("some", v, 0)
+			if err != nil {
+				return err
+			}
+			seq, err := seqArg("some", v, 1)
+			if err != nil {
+				return err
+			}
+			for _, x := range seq {
+				ok, e := predicate("some", f, x)
+				if e != nil {
+					return e
+				}
+				if ok {
+					return true
+				}
+			}
+			return false
+		},
+		"every?": func(v []Object) Object { // (every? pred lst) 是否所有元素都使pred为true
+			if err := checkArity("every?", v, 2, 2); err != nil {
+				return err
+			}
+			f, err := funcArg("every?", v, 0)
+			if err != nil {
+				return err
+			}
+			seq, err := seqArg("every?", v, 1)
+			if err != nil {
+				return err
+			}
+			for _, x := range seq {
+				ok, e := predicate("every?", f, x)
+				if e != nil {
+					return e
+				}
+				if !ok {
+					return false
+				}
+			}
+			return true
+		},
+		"sort": func(v []Object) Object { // (sort lst) 或 (sort less lst)，less(a, b)为true时a排在b前，排序稳定，返回新列表
+			if err := checkArity("sort", v, 1, 2); err != nil {
+				return err
+			}
+			var f Object
+			if len(v) == 2 {
+				var err *LispError
+				if f, err = funcArg("sort", v, 0); err != nil {
+					return err
+				}
+			}
+			seq, err := seqArg("sort", v, len(v)-1)
+			if err != nil {
+				return err
+			}
+			res := append([]Object{}, seq...)
+			var failed Object
+			sort.SliceStable(res, func(i, j int) bool {
+				if failed != nil {
+					return false
+				}
+				if f != nil {
+					ok, e := predicate("sort", f, res[i], res[j])
+					failed = e
+					return ok
+				}
+				ok, e := less(res[i], res[j])
+				if e != nil {
+					failed = e
+				}
+				return ok
+			})
+			if failed != nil {
+				return failed
+			}
+			return res
+		},
+		"range": func(v []Object) Object { // (range end) (range start end) (range start end step)，不含end
+			nums, err := numArgs("range", v, 1, 3)
+			if err != nil {
+				return err
+			}
+			var start, end, step Object = int64(0), nums[0], int64(1)
+			if len(nums) > 1 {
+				start, end = nums[0], nums[1]
+			}
+			if len(nums) > 2 {
+				step = nums[2]
+			}
+			dir := NumCmp(step, int64(0))
+			if dir == 0 {
+				return NewError(ArithError, "range 的步长不能为0")
+			}
+			if n := NumDiv(NumSub(end, start), step); !isNaN(n) && NumCmp(n, int64(maxRange)) > 0 { // 先估算长度，避免耗尽内存
+				return NewError(ArithError, "range 的长度超过%v", maxRange)
+			}
+			res := []Object{}
+			for x := start; NumCmp(x, end) == -dir; {
+				res = append(res, x)
+				next := NumAdd(x, step)
+				if NumCmp(next, x) == 0 { // 浮点数过大时加上步长不变，否则不会结束
+					return NewError(ArithError, "range 的步长 %v 相对 %v 过小", ToString(step, true), ToString(x, true))
+				}
+				x = next
+			}
+			return res
+		},
+	}
+}
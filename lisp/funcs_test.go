@@ -0,0 +1,19 @@
+package lisp
+
+import "testing"
+
+func TestRange(t *testing.T) {
+	expect(t, [][2]string{
+		{"(range 3)", "(0 1 2)"},
+		{"(range 1 10 3)", "(1 4 7)"},
+		{"(range 3 0 -1)", "(3 2 1)"},
+		{"(range 0 1 0.25)", "(0 0.25 0.5 0.75)"},
+		{"(range 0 1 1/3)", "(0 1/3 2/3)"},
+		{"(range 5 1)", "()"},
+		{"(range 0 1 0)", "1:1: 算术错误: range 的步长不能为0"},
+		{"(range 0 10000000000)", "1:1: 算术错误: range 的长度超过10000000"},
+		{"(range 0 (/ 1.0 0.0))", "1:1: 算术错误: range 的长度超过10000000"},
+		{"(range 1e17 (+ 1e17 10))", "1:1: 算术错误: range 的步长 1 相对 1e+17 过小"},
+		{"(range (- 0 1e17) (+ (- 0 1e17) 10) 1.0)", "1:1: 算术错误: range 的步长 1.0 相对 -1e+17 过小"},
+	})
+}
@@ -0,0 +1,3 @@
+module github.com/pysrc/Make_Lisp/lisp
+
+go 1.21
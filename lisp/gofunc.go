@@ -0,0 +1,410 @@
+package lisp
+
+import (
+	"fmt"
+	"math"
+	"math/big"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+var errorType = reflect.TypeOf((*error)(nil)).Elem()
+
+func (self *Interpreter) RegisterFunc(name string, f interface{}) error { // 将任意Go函数注册为全局函数，参数和结果通过反射转换
+	fv := reflect.ValueOf(f)
+	if fv.Kind() != reflect.Func {
+		return fmt.Errorf("RegisterFunc: %v 不是函数", fv.Type())
+	}
+	self.Define(name, wrapGoFunc(name, fv))
+	return nil
+}
+
+func wrapGoFunc(name string, fv reflect.Value) func([]Object) Object { // 包装Go函数：转换参数，调用，转换结果，最后一个结果为error时转为错误值
+	ft := fv.Type()
+	min, max := ft.NumIn(), ft.NumIn()
+	if ft.IsVariadic() {
+		min, max = min-1, -1
+	}
+	return func(v []Object) (res Object) {
+		defer func() { // 转换参数或调用时的panic转为错误值
+			if r := recover(); r != nil {
+				res = NewError(GoError, "%v: %v", name, r)
+			}
+		}()
+		if err := checkArity(name, v, min, max); err != nil {
+			return err
+		}
+		in := make([]reflect.Value, len(v))
+		for i, a := range v {
+			var t reflect.Type
+			if ft.IsVariadic() && i >= min {
+				t = ft.In(min).Elem()
+			} else {
+				t = ft.In(i)
+			}
+			x, ok := toGo(a, t)
+			if !ok {
+				err := NewError(TypeError, "%v 的第%v个参数应为 %v, 实际为 %v", name, i+1, t, ToString(a, true))
+				err.idx = i + 1
+				return err
+			}
+			in[i] = x
+		}
+		out := fv.Call(in)
+		if n := len(out); n > 0 && ft.Out(n-1) == errorType {
+			if err := out[n-1]; !err.IsNil() {
+				return NewError(GoError, "%v: %v", name, err.Interface())
+			}
+			out = out[:n-1]
+		}
+		switch len(out) {
+		case 0:
+			return nil
+		case 1:
+			return fromGo(out[0])
+		}
+		lt := make([]Object, len(out)) // 多个结果组成列表
+		for i, o := range out {
+			lt[i] = fromGo(o)
+		}
+		return lt
+	}
+}
+
+func toGo(v Object, t reflect.Type) (reflect.Value, bool) { // 将Lisp值转换为Go类型t的值
+	if t.Kind() == reflect.Interface && t.NumMethod() == 0 { // 参数为 interface{} 时转为最接近的Go值
+		if v == nil {
+			return reflect.Zero(t), true
+		}
+		return reflect.ValueOf(natural(v)).Convert(t), true
+	}
+	switch t.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, ok := v.(int64)
+		if !ok || reflect.Zero(t).OverflowInt(n) {
+			return reflect.Value{}, false
+		}
+		return reflect.ValueOf(n).Convert(t), true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		var n uint64
+		switch x := v.(type) {
+		case int64:
+			if x < 0 {
+				return reflect.Value{}, false
+			}
+			n = uint64(x)
+		case *big.Int:
+			if !x.IsUint64() {
+				return reflect.Value{}, false
+			}
+			n = x.Uint64()
+		default:
+			return reflect.Value{}, false
+		}
+		if reflect.Zero(t).OverflowUint(n) {
+			return reflect.Value{}, false
+		}
+		return reflect.ValueOf(n).Convert(t), true
+	case reflect.Float32, reflect.Float64:
+		if !IsNumber(v) {
+			return reflect.Value{}, false
+		}
+		return reflect.ValueOf(ToFloat(v)).Convert(t), true
+	case reflect.String:
+		s, ok := v.(Str)
+		if !ok {
+			return reflect.Value{}, false
+		}
+		return reflect.ValueOf(string(s)).Convert(t), true
+	case reflect.Bool:
+		b, ok := v.(bool)
+		if !ok {
+			return reflect.Value{}, false
+		}
+		return reflect.ValueOf(b).Convert(t), true
+	case reflect.Slice:
+		if v == nil {
+			return reflect.Zero(t), true
+		}
+		lt, ok := v.([]Object)
+		if !ok {
+			return reflect.Value{}, false
+		}
+		res := reflect.MakeSlice(t, len(lt), len(lt))
+		for i, item := range lt {
+			x, ok := toGo(item, t.Elem())
+			if !ok {
+				return reflect.Value{}, false
+			}
+			res.Index(i).Set(x)
+		}
+		return res, true
+	case reflect.Map:
+		if v == nil {
+			return reflect.Zero(t), true
+		}
+		m, ok := v.(*HashMap)
+		if !ok {
+			return reflect.Value{}, false
+		}
+		res := reflect.MakeMapWithSize(t, m.Len())
+		for _, hk := range m.order {
+			e := m.entries[hk]
+			k, ok1 := toGo(e.Key, t.Key())
+			x, ok2 := toGo(e.Val, t.Elem())
+			if !ok1 || !ok2 {
+				return reflect.Value{}, false
+			}
+			res.SetMapIndex(k, x)
+		}
+		return res, true
+	case reflect.Struct:
+		m, ok := v.(*HashMap)
+		if !ok {
+			return reflect.Value{}, false
+		}
+		res := reflect.New(t).Elem()
+		for i := 0; i < t.NumField(); i++ {
+			field := t.Field(i)
+			if field.PkgPath != "" { // 未导出的字段
+				continue
+			}
+			val, found := lookupField(m, field)
+			if !found {
+				continue
+			}
+			x, ok := toGo(val, field.Type)
+			if !ok {
+				return reflect.Value{}, false
+			}
+			res.Field(i).Set(x)
+		}
+		return res, true
+	case reflect.Ptr:
+		if v == nil {
+			return reflect.Zero(t), true
+		}
+		if x, ok := toGo(v, t.Elem()); ok {
+			p := reflect.New(t.Elem())
+			p.Elem().Set(x)
+			return p, true
+		}
+	case reflect.Func:
+		if v != nil && reflect.TypeOf(v).AssignableTo(t) {
+			return reflect.ValueOf(v), true
+		}
+		if !IsFunc(v) {
+			return reflect.Value{}, false
+		}
+		return makeGoFunc(v, t), true
+	}
+	if v != nil && reflect.TypeOf(v).AssignableTo(t) { // 其余情况直接传递，如参数类型为 lisp.Fn、*big.Int
+		return reflect.ValueOf(v), true
+	}
+	return reflect.Value{}, false
+}
+
+func natural(v Object) interface{} { // Lisp值对应的Go值：字符串为string，列表为[]interface{}，哈希表为map[interface{}]interface{}
+	switch x := v.(type) {
+	case Str:
+		return string(x)
+	case []Object:
+		res := make([]interface{}, len(x))
+		for i, item := range x {
+			res[i] = natural(item)
+		}
+		return res
+	case *HashMap:
+		res := make(map[interface{}]interface{}, x.Len())
+		for _, hk := range x.order {
+			e := x.entries[hk]
+			res[natural(e.Key)] = natural(e.Val)
+		}
+		return res
+	}
+	return v
+}
+
+func fromGo(x reflect.Value) Object { // 将Go值转换为Lisp值
+	if !x.IsValid() {
+		return nil
+	}
+	if x.CanInterface() {
+		switch o := x.Interface().(type) { // 已经是Lisp值
+		case Str, *Symbol, []Object, Fn, *HashMap, *Set, *big.Int, *big.Rat, *LispError, ErrorValue, *Module, func([]Object) Object:
+			return o
+		}
+	}
+	switch x.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return x.Int()
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		if n := x.Uint(); n > math.MaxInt64 {
+			return new(big.Int).SetUint64(n)
+		}
+		return int64(x.Uint())
+	case reflect.Float32, reflect.Float64:
+		return x.Float()
+	case reflect.String:
+		return Str(x.String())
+	case reflect.Bool:
+		return x.Bool()
+	case reflect.Slice, reflect.Array:
+		if x.Kind() == reflect.Slice && x.IsNil() {
+			return nil
+		}
+		res := make([]Object, x.Len())
+		for i := range res {
+			res[i] = fromGo(x.Index(i))
+		}
+		return res
+	case reflect.Map:
+		if x.IsNil() {
+			return nil
+		}
+		keys := x.MapKeys()
+		ks := make([]Object, len(keys))
+		for i, k := range keys {
+			ks[i] = fromGo(k)
+		}
+		idx := make([]int, len(keys)) // Go的map无序，按键排序使结果稳定
+		for i := range idx {
+			idx[i] = i
+		}
+		sort.Slice(idx, func(i, j int) bool {
+			if ok, err := less(ks[idx[i]], ks[idx[j]]); err == nil {
+				return ok
+			}
+			return ToString(ks[idx[i]], true) < ToString(ks[idx[j]], true)
+		})
+		m := NewHashMap()
+		for _, i := range idx {
+			m.put(ks[i], fromGo(x.MapIndex(keys[i])))
+		}
+		return m
+	case reflect.Struct: // 结构体转为以字段名为键的哈希表
+		m := NewHashMap()
+		t := x.Type()
+		for i := 0; i < t.NumField(); i++ {
+			if field := t.Field(i); field.PkgPath == "" {
+				m.put(Str(fieldName(field)), fromGo(x.Field(i)))
+			}
+		}
+		return m
+	case reflect.Ptr, reflect.Interface:
+		if x.IsNil() {
+			return nil
+		}
+		return fromGo(x.Elem())
+	}
+	if x.CanInterface() {
+		return x.Interface()
+	}
+	return nil
+}
+
+func fieldName(field reflect.StructField) string { // 字段在哈希表中的键名，可用 lisp:"name" 标签指定
+	if tag := field.Tag.Get("lisp"); tag != "" {
+		return tag
+	}
+	return field.Name
+}
+
+func lookupField(m *HashMap, field reflect.StructField) (Object, bool) { // 在哈希表中查找字段的值，键可为字符串或符号，也可为小写的字段名，或不区分大小写的关键字 :name
+	name := fieldName(field)
+	for _, key := range []string{name, strings.ToLower(name)} {
+		if v, ok := m.Get(Str(key)); ok {
+			return v, true
+		}
+		if v, ok := m.Get(Sym(key)); ok {
+			return v, true
+		}
+	}
+	for _, hk := range m.order {
+		e := m.entries[hk]
+		if sym, ok := e.Key.(*Symbol); ok && sym.IsKeyword() && strings.EqualFold(sym.Name[1:], name) {
+			return e.Val, true
+		}
+	}
+	return nil, false
+}
+
+func FromGo(x interface{}) Object { // 将Go值转换为Lisp值，结构体转为哈希表
+	return fromGo(reflect.ValueOf(x))
+}
+
+func Decode(v Object, out interface{}) error { // 将Lisp值转换后存入out指向的Go变量，哈希表可转为结构体
+	rv := reflect.ValueOf(out)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() {
+		return fmt.Errorf("Decode: out 应为非nil指针, 实际为 %T", out)
+	}
+	x, ok := toGo(v, rv.Elem().Type())
+	if !ok {
+		return NewError(TypeError, "无法将 %v 转换为 %v", ToString(v, true), rv.Elem().Type())
+	}
+	rv.Elem().Set(x)
+	return nil
+}
+
+func makeGoFunc(f Object, t reflect.Type) reflect.Value { // 将Lisp函数包装为Go函数类型t
+	var in *Interpreter // 用于补全错误位置
+	if fc, ok := f.(Fn); ok && fc.Env != nil {
+		in = fc.Env.in
+	}
+	hasErr := t.NumOut() > 0 && t.Out(t.NumOut()-1) == errorType
+	return reflect.MakeFunc(t, func(args []reflect.Value) []reflect.Value {
+		if t.IsVariadic() { // 展开可变参数
+			last := args[len(args)-1]
+			args = args[:len(args)-1]
+			for i := 0; i < last.Len(); i++ {
+				args = append(args, last.Index(i))
+			}
+		}
+		lt := make([]Object, len(args))
+		for i, a := range args {
+			lt[i] = fromGo(a)
+		}
+		out, err := decodeResults(Invoke(f, lt), t, hasErr)
+		if err != nil {
+			if in != nil {
+				err = in.locate(err)
+			}
+			if !hasErr { // 没有error结果时只能panic
+				panic(err)
+			}
+			out[len(out)-1] = reflect.ValueOf(error(err))
+		}
+		return out
+	})
+}
+
+func decodeResults(res Object, t reflect.Type, hasErr bool) ([]reflect.Value, *LispError) { // 将Lisp函数的结果转换为Go函数t的结果，多个结果时Lisp函数应返回列表
+	out := make([]reflect.Value, t.NumOut())
+	for i := range out {
+		out[i] = reflect.Zero(t.Out(i))
+	}
+	if err, ok := res.(*LispError); ok {
+		return out, err
+	}
+	n := len(out)
+	if hasErr {
+		n--
+	}
+	vals := []Object{res}
+	if n > 1 {
+		lt, ok := res.([]Object)
+		if !ok || len(lt) != n {
+			return out, NewError(TypeError, "应返回%v个值的列表, 实际为 %v", n, ToString(res, true))
+		}
+		vals = lt
+	}
+	for i := 0; i < n; i++ {
+		x, ok := toGo(vals[i], t.Out(i))
+		if !ok {
+			return out, NewError(TypeError, "无法将结果 %v 转换为 %v", ToString(vals[i], true), t.Out(i))
+		}
+		out[i] = x
+	}
+	return out, nil
+}
@@ -0,0 +1,244 @@
+package lisp
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+type point struct {
+	X, Y  int
+	Label string `lisp:"name"`
+	note  string
+}
+
+func registerAll(in *Interpreter) { // 注册测试用的Go函数
+	funcs := map[string]interface{}{
+		"add":   func(a, b int) int { return a + b },
+		"half":  func(x float64) float32 { return float32(x / 2) },
+		"small": func(n int8) int8 { return n },
+		"count": func(n uint) uint64 { return uint64(n) * 1e10 },
+		"upper": strings.ToUpper,
+		"not":   func(b bool) bool { return !b },
+		"sum": func(xs []int) (s int) {
+			for _, x := range xs {
+				s += x
+			}
+			return
+		},
+		"words": func(s string) []string { return strings.Fields(s) },
+		"total": func(m map[string]int) (s int) {
+			for _, x := range m {
+				s += x
+			}
+			return
+		},
+		"inverse": func(m map[string]int) map[int]string {
+			r := map[int]string{}
+			for k, v := range m {
+				r[v] = k
+			}
+			return r
+		},
+		"move": func(p point, dx int) point { p.X += dx; return p },
+		"norm": func(p *point) int {
+			if p == nil {
+				return -1
+			}
+			return p.X*p.X + p.Y*p.Y
+		},
+		"join":  func(sep string, parts ...string) string { return strings.Join(parts, sep) },
+		"twice": func(f func(int) int, x int) int { return f(f(x)) },
+		"divmod": func(a, b int) (int, int, error) {
+			if b == 0 {
+				return 0, 0, errors.New("除数为0")
+			}
+			return a / b, a % b, nil
+		},
+		"check": func(x int) error {
+			if x < 0 {
+				return fmt.Errorf("负数 %d", x)
+			}
+			return nil
+		},
+		"boom": func() int { panic("爆炸") },
+		"any":  func(x interface{}) string { return fmt.Sprintf("%T", x) },
+	}
+	for name, f := range funcs {
+		if err := in.RegisterFunc(name, f); err != nil {
+			panic(err)
+		}
+	}
+}
+
+func TestRegisterFunc(t *testing.T) {
+	expectIn(t, registerAll, [][2]string{
+		{"(add 1 2)", "3"},
+		{"(half 3)", "1.5"},
+		{"(small 127)", "127"},
+		{"(small 128)", "1:8: 类型错误: small 的第1个参数应为 int8, 实际为 128"},
+		{"(count 2)", "20000000000"},
+		{"(count -1)", "1:8: 类型错误: count 的第1个参数应为 uint, 实际为 -1"},
+		{`(upper "abc")`, `"ABC"`},
+		{"(not true)", "false"},
+		{"(add 1 \"x\")", `1:8: 类型错误: add 的第2个参数应为 int, 实际为 "x"`},
+		{"(add 1)", "1:1: 参数个数错误: add 需要2个参数, 实际为1个"},
+		{"(sum (list 1 2 3))", "6"},
+		{"(sum nil)", "0"},
+		{"(sum (list 1 \"a\"))", `1:6: 类型错误: sum 的第1个参数应为 []int, 实际为 (1 "a")`},
+		{`(words "a b  c")`, `("a" "b" "c")`},
+		{`(total %{"a" 1 "b" 2})`, "3"},
+		{`(inverse %{"a" 1 "b" 2})`, `%{1 "a" 2 "b"}`},
+		{`(move %{"X" 1 "y" 2 "name" "p"} 10)`, `%{"X" 11 "Y" 2 "name" "p"}`},
+		{`(move %{'x 1} 1)`, `%{"X" 2 "Y" 0 "name" ""}`},
+		{`(move %{:x 1 :NAME "p"} 1)`, `%{"X" 2 "Y" 0 "name" "p"}`},
+		{`(move %{"X" "a"} 1)`, `1:7: 类型错误: move 的第1个参数应为 lisp.point, 实际为 %{"X" "a"}`},
+		{`(norm %{"X" 3 "Y" 4})`, "25"},
+		{"(norm nil)", "-1"},
+		{`(join "-")`, `""`},
+		{`(join "-" "a" "b" "c")`, `"a-b-c"`},
+		{`(join "-" "a" 1)`, `1:15: 类型错误: join 的第3个参数应为 string, 实际为 1`},
+		{"(twice (lambda [x] {(ret (* x 3))}) 2)", "18"},
+		{"(twice + 2)", "2"},
+		{"(divmod 7 2)", "(3 1)"},
+		{"(divmod 7 0)", "1:1: Go函数错误: divmod: 除数为0"},
+		{"(check 1)", "nil"},
+		{"(check -1)", "1:1: Go函数错误: check: 负数 -1"},
+		{"(boom)", "1:1: Go函数错误: boom: 爆炸"},
+		{`(list (any 1) (any "s") (any (list 1)) (any %{1 2}))`, `("int64" "string" "[]interface {}" "map[interface {}]interface {}")`},
+	})
+}
+
+func TestCallTo(t *testing.T) {
+	in := New()
+	if _, err := in.Eval(`
+S:
+(fn area [p] {(ret (* (get p "X") (get p "Y")))})
+(fn pair [a b] {(ret (list a b))})
+(fn scale [xs k] {(ret (map (lambda [x] {(ret (* x k))}) xs))})
+(fn fail [] {(ret (+ 1 "a"))})
+:E
+`); err != nil {
+		t.Fatal(err)
+	}
+	var n int
+	if err := in.CallTo(&n, "area", point{X: 3, Y: 4}); err != nil || n != 12 {
+		t.Fatalf("area = %v, %v", n, err)
+	}
+	var pair []interface{}
+	if err := in.CallTo(&pair, "pair", "a", 1.5); err != nil || !reflect.DeepEqual(pair, []interface{}{"a", 1.5}) {
+		t.Fatalf("pair = %#v, %v", pair, err)
+	}
+	var scaled []float64
+	if err := in.CallTo(&scaled, "scale", []int{1, 2}, 0.5); err != nil || !reflect.DeepEqual(scaled, []float64{0.5, 1}) {
+		t.Fatalf("scale = %v, %v", scaled, err)
+	}
+	if err := in.CallTo(nil, "pair", 1, 2); err != nil {
+		t.Fatal(err)
+	}
+	var s string
+	if err := in.CallTo(&s, "area", point{X: 3, Y: 4}); err == nil || err.Error() != "类型错误: 无法将 12 转换为 string" {
+		t.Fatalf("area to string: %v", err)
+	}
+	if err := in.CallTo(&n, "fail"); err == nil || !strings.Contains(err.Error(), "+ 的第2个参数应为数字") {
+		t.Fatalf("fail: %v", err)
+	}
+	if err := in.CallTo(&n, "nosuch"); err == nil || err.Error() != "未定义符号: 未定义的函数 nosuch" {
+		t.Fatalf("nosuch: %v", err)
+	}
+}
+
+func TestBind(t *testing.T) {
+	in := New()
+	if _, err := in.Eval(`
+S:
+(fn fib [n] {(if (<= n 2) {(ret 1)}) (ret (+ (fib (- n 1)) (fib (- n 2))))})
+(fn divide [a b] {(if (== b 0) {(throw "除数为0")}) (ret (list (/ (- a (% a b)) b) (% a b)))})
+:E
+`); err != nil {
+		t.Fatal(err)
+	}
+	var fib func(int) int
+	if err := in.Bind("fib", &fib); err != nil {
+		t.Fatal(err)
+	}
+	if n := fib(20); n != 6765 {
+		t.Fatalf("fib(20) = %v", n)
+	}
+	func() { // 没有error结果时，出错会panic
+		defer func() {
+			if r := recover(); r == nil || !strings.Contains(fmt.Sprint(r), "无法将结果") {
+				t.Fatalf("recover = %v", r)
+			}
+		}()
+		var bad func(int) string
+		if err := in.Bind("fib", &bad); err != nil {
+			t.Fatal(err)
+		}
+		bad(3)
+	}()
+	var divide func(a, b int) (int, int, error)
+	if err := in.Bind("divide", &divide); err != nil {
+		t.Fatal(err)
+	}
+	if q, r, err := divide(7, 2); q != 3 || r != 1 || err != nil {
+		t.Fatalf("divide(7, 2) = %v, %v, %v", q, r, err)
+	}
+	if _, _, err := divide(1, 0); err == nil || !strings.Contains(err.Error(), "用户错误: 除数为0") {
+		t.Fatalf("divide(1, 0) error = %v", err)
+	}
+	if err := in.Bind("nosuch", &fib); err == nil || err.Error() != "未定义符号: 未定义的函数 nosuch" {
+		t.Fatalf("Bind nosuch: %v", err)
+	}
+	if err := in.Bind("fib", fib); err == nil {
+		t.Fatal("Bind 的参数应为函数指针")
+	}
+}
+
+type shape struct {
+	Name   string
+	Points []point
+	Tags   map[string]bool `lisp:"tags"`
+	Center *point
+}
+
+func TestDecode(t *testing.T) {
+	in := New()
+	v, err := in.Eval(`(val %{"Name" "tri" "Points" (list %{"X" 1 "Y" 2} %{"X" 3 "name" "b"}) "tags" %{"red" true} "Center" %{"Y" 5}})`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var s shape
+	if err := Decode(v, &s); err != nil {
+		t.Fatal(err)
+	}
+	want := shape{"tri", []point{{X: 1, Y: 2}, {X: 3, Label: "b"}}, map[string]bool{"red": true}, &point{Y: 5}}
+	if !reflect.DeepEqual(s, want) {
+		t.Fatalf("Decode = %+v", s)
+	}
+	if v, err = in.Eval(`(val %{:name "tri" :points (list %{:x 1 :Y 2} %{:X 3 :Name "b"}) :TAGS %{"red" true} :center %{:y 5}})`); err != nil {
+		t.Fatal(err)
+	}
+	s = shape{}
+	if err := Decode(v, &s); err != nil || !reflect.DeepEqual(s, want) { // 关键字键不区分大小写
+		t.Fatalf("Decode = %+v, %v", s, err)
+	}
+	var m map[string][]int
+	if v, err = in.Eval(`(val %{"a" (list 1 2) "b" nil})`); err != nil {
+		t.Fatal(err)
+	}
+	if err := Decode(v, &m); err != nil || !reflect.DeepEqual(m, map[string][]int{"a": {1, 2}, "b": nil}) {
+		t.Fatalf("Decode = %v, %v", m, err)
+	}
+	if v, err = in.Eval(`(val %{"Name" 1})`); err != nil {
+		t.Fatal(err)
+	}
+	if err := Decode(v, &s); err == nil || err.Error() != `类型错误: 无法将 %{"Name" 1} 转换为 lisp.shape` {
+		t.Fatalf("Decode mismatch: %v", err)
+	}
+	if err := Decode(int64(1), s); err == nil {
+		t.Fatal("Decode 的 out 应为指针")
+	}
+}
@@ -0,0 +1,373 @@
+package lisp
+
+import (
+	"math"
+	"math/big"
+)
+
+type HashMap struct { // 哈希表，键可为数字、字符串、符号、bool，按插入顺序遍历，修改时返回新表
+	order   []interface{}
+	entries map[interface{}]mapEntry
+}
+
+type mapEntry struct {
+	Key Object
+	Val Object
+}
+
+type Set struct { // 集合，元素要求与哈希表的键相同
+	m *HashMap
+}
+
+type bigKey string // *big.Int、*big.Rat 不能直接作为Go的map键，转为字符串
+type ratKey string
+
+func hashKey(k Object) (interface{}, bool) { // 取得可作为Go map键的值，相等的数字键相同，如 1、1.0、2/2
+	switch x := k.(type) {
+	case int64, bool, Str, *Symbol, nil:
+		return x, true
+	case *big.Int:
+		if x.IsInt64() {
+			return x.Int64(), true
+		}
+		return bigKey(x.String()), true
+	case *big.Rat:
+		if x.IsInt() {
+			return hashKey(x.Num())
+		}
+		if f, exact := x.Float64(); exact { // 与相等的浮点数一致，如 1/2 与 0.5
+			return f, true
+		}
+		return ratKey(x.String()), true
+	case float64:
+		switch {
+		case math.IsNaN(x): // NaN 不能作为键，否则存入后无法取出
+			return nil, false
+		case math.IsInf(x, 0) || x != math.Trunc(x):
+			return x, true
+		case x >= -(1<<63) && x < 1<<63:
+			return int64(x), true
+		}
+		n, _ := new(big.Float).SetFloat64(x).Int(nil)
+		return bigKey(n.String()), true
+	}
+	return nil, false
+}
+
+func NewHashMap() *HashMap {
+	return &HashMap{entries: make(map[interface{}]mapEntry)}
+}
+
+func (self *HashMap) Len() int {
+	return len(self.order)
+}
+
+func (self *HashMap) Get(k Object) (Object, bool) {
+	hk, ok := hashKey(k)
+	if !ok {
+		return nil, false
+	}
+	e, ok := self.entries[hk]
+	return e.Val, ok
+}
+
+func (self *HashMap) Keys() []Object {
+	res := make([]Object, len(self.order))
+	for i, hk := range self.order {
+		res[i] = self.entries[hk].Key
+	}
+	return res
+}
+
+func (self *HashMap) Vals() []Object {
+	res := make([]Object, len(self.order))
+	for i, hk := range self.order {
+		res[i] = self.entries[hk].Val
+	}
+	return res
+}
+
+func (self *HashMap) Copy() *HashMap {
+	m := &HashMap{append([]interface{}{}, self.order...), make(map[interface{}]mapEntry, len(self.entries))}
+	for k, e := range self.entries {
+		m.entries[k] = e
+	}
+	return m
+}
+
+func (self *HashMap) put(k, v Object) *LispError { // 原地设置，只用于构造新表
+	hk, ok := hashKey(k)
+	if !ok {
+		return NewError(TypeError, "%v 不能作为键", ToString(k, true))
+	}
+	if e, ok := self.entries[hk]; ok {
+		k = e.Key // 相等的键保留原来的写法，如已有 1 时设置 1.0
+	} else {
+		self.order = append(self.order, hk)
+	}
+	self.entries[hk] = mapEntry{k, v}
+	return nil
+}
+
+func (self *HashMap) remove(k Object) { // 原地删除，只用于构造新表
+	hk, ok := hashKey(k)
+	if !ok {
+		return
+	}
+	if _, ok := self.entries[hk]; !ok {
+		return
+	}
+	delete(self.entries, hk)
+	for i, o := range self.order {
+		if o == hk {
+			self.order = append(self.order[:i:i], self.order[i+1:]...)
+			break
+		}
+	}
+}
+
+func NewSet(items ...Object) (*Set, *LispError) {
+	s := &Set{NewHashMap()}
+	for _, item := range items {
+		if err := s.m.put(item, true); err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+func (self *Set) Len() int {
+	return self.m.Len()
+}
+
+func (self *Set) Contains(x Object) bool {
+	_, ok := self.m.Get(x)
+	return ok
+}
+
+func (self *Set) Items() []Object {
+	return self.m.Keys()
+}
+
+func Equal(a, b Object) bool { // 判断两个值是否相等，数字按值比较，列表、哈希表、集合逐项比较，函数不相等
+	if IsNumber(a) && IsNumber(b) {
+		return !isNaN(a) && !isNaN(b) && NumCmp(a, b) == 0 // NaN 与任何值都不相等
+	}
+	switch x := a.(type) {
+	case []Object:
+		y, ok := b.([]Object)
+		if !ok || len(x) != len(y) {
+			return false
+		}
+		for i := range x {
+			if !Equal(x[i], y[i]) {
+				return false
+			}
+		}
+		return true
+	case *HashMap:
+		y, ok := b.(*HashMap)
+		if !ok || x.Len() != y.Len() {
+			return false
+		}
+		for _, e := range x.entries {
+			v, ok := y.Get(e.Key)
+			if !ok || !Equal(e.Val, v) {
+				return false
+			}
+		}
+		return true
+	case *Set:
+		y, ok := b.(*Set)
+		if !ok || x.Len() != y.Len() {
+			return false
+		}
+		for _, item := range x.Items() {
+			if !y.Contains(item) {
+				return false
+			}
+		}
+		return true
+	}
+	if _, ok := hashKey(a); ok {
+		return a == b
+	}
+	return false
+}
+
+func mapArg(name string, v []Object, i int) (*HashMap, *LispError) { // 取出第i个参数作为哈希表，nil视为空表
+	switch m := v[i].(type) {
+	case nil:
+		return NewHashMap(), nil
+	case *HashMap:
+		return m, nil
+	}
+	err := NewError(TypeError, "%v 的第%v个参数应为哈希表, 实际为 %v", name, i+1, ToString(v[i], true))
+	err.idx = i + 1
+	return nil, err
+}
+
+func setArg(name string, v []Object, i int) (*Set, *LispError) { // 取出第i个参数作为集合
+	if s, ok := v[i].(*Set); ok {
+		return s, nil
+	}
+	err := NewError(TypeError, "%v 的第%v个参数应为集合, 实际为 %v", name, i+1, ToString(v[i], true))
+	err.idx = i + 1
+	return nil, err
+}
+
+func setOp(name string, keep func(in_all, in_rest, in_prev, first bool) bool) func([]Object) Object { // 包装集合运算
+	return func(v []Object) Object {
+		if err := checkArity(name, v, 1, -1); err != nil {
+			return err
+		}
+		sets := make([]*Set, len(v))
+		for i := range v {
+			s, err := setArg(name, v, i)
+			if err != nil {
+				return err
+			}
+			sets[i] = s
+		}
+		var items []Object
+		for i, s := range sets {
+			for _, item := range s.Items() {
+				in_all, in_rest, in_prev := true, false, false
+				for j, t := range sets {
+					if j != i {
+						c := t.Contains(item)
+						in_all = in_all && c
+						in_rest = in_rest || c
+						in_prev = in_prev || (c && j < i)
+					}
+				}
+				if keep(in_all, in_rest, in_prev, i == 0) {
+					items = append(items, item)
+				}
+			}
+		}
+		res, _ := NewSet(items...)
+		return res
+	}
+}
+
+func mapBuiltins() map[string]Object { // 哈希表与集合函数
+	return map[string]Object{
+		"hash-map": func(v []Object) Object { // (hash-map k1 v1 k2 v2 ...)，字面量为 %{k1 v1 k2 v2 ...}
+			if len(v)%2 != 0 {
+				return NewError(ArityError, "hash-map 的参数应为成对的键和值")
+			}
+			m := NewHashMap()
+			for i := 0; i < len(v); i += 2 {
+				if err := m.put(v[i], v[i+1]); err != nil {
+					err.idx = i + 1
+					return err
+				}
+			}
+			return m
+		},
+		"get": func(v []Object) Object { // (get m k) 或 (get m k default)，集合中存在时返回元素本身
+			if err := checkArity("get", v, 2, 3); err != nil {
+				return err
+			}
+			if s, ok := v[0].(*Set); ok {
+				if s.Contains(v[1]) {
+					return v[1]
+				}
+			} else {
+				m, err := mapArg("get", v, 0)
+				if err != nil {
+					return err
+				}
+				if val, ok := m.Get(v[1]); ok {
+					return val
+				}
+			}
+			if len(v) == 3 {
+				return v[2]
+			}
+			return nil
+		},
+		"assoc": func(v []Object) Object { // (assoc m k1 v1 k2 v2 ...) 返回加入键值后的新表
+			if len(v) < 1 || len(v)%2 != 1 {
+				return NewError(ArityError, "assoc 的参数应为 (assoc m k1 v1 k2 v2 ...)")
+			}
+			m, err := mapArg("assoc", v, 0)
+			if err != nil {
+				return err
+			}
+			m = m.Copy()
+			for i := 1; i < len(v); i += 2 {
+				if err := m.put(v[i], v[i+1]); err != nil {
+					err.idx = i + 1
+					return err
+				}
+			}
+			return m
+		},
+		"dissoc": func(v []Object) Object { // (dissoc m k1 k2 ...) 返回删除键后的新表
+			if err := checkArity("dissoc", v, 1, -1); err != nil {
+				return err
+			}
+			m, err := mapArg("dissoc", v, 0)
+			if err != nil {
+				return err
+			}
+			m = m.Copy()
+			for _, k := range v[1:] {
+				m.remove(k)
+			}
+			return m
+		},
+		"keys": func(v []Object) Object {
+			if err := checkArity("keys", v, 1, 1); err != nil {
+				return err
+			}
+			m, err := mapArg("keys", v, 0)
+			if err != nil {
+				return err
+			}
+			return m.Keys()
+		},
+		"vals": func(v []Object) Object {
+			if err := checkArity("vals", v, 1, 1); err != nil {
+				return err
+			}
+			m, err := mapArg("vals", v, 0)
+			if err != nil {
+				return err
+			}
+			return m.Vals()
+		},
+		"contains?": func(v []Object) Object { // (contains? m k) 哈希表中是否有键k，集合中是否有元素k
+			if err := checkArity("contains?", v, 2, 2); err != nil {
+				return err
+			}
+			if s, ok := v[0].(*Set); ok {
+				return s.Contains(v[1])
+			}
+			m, err := mapArg("contains?", v, 0)
+			if err != nil {
+				return err
+			}
+			_, ok := m.Get(v[1])
+			return ok
+		},
+		"hash-set": func(v []Object) Object { // (hash-set a b ...)，字面量为 %[a b ...]
+			s, err := NewSet(v...)
+			if err != nil {
+				return err
+			}
+			return s
+		},
+		"union": setOp("union", func(in_all, in_rest, in_prev, first bool) bool { // 并集，每个元素取最先出现的
+			return !in_prev
+		}),
+		"intersection": setOp("intersection", func(in_all, in_rest, in_prev, first bool) bool { // 交集
+			return first && in_all
+		}),
+		"difference": setOp("difference", func(in_all, in_rest, in_prev, first bool) bool { // 差集，第一个集合中不在其余集合中的元素
+			return first && !in_rest
+		}),
+	}
+}
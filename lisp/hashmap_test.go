@@ -0,0 +1,89 @@
+package lisp
+
+import (
+	"fmt"
+	"testing"
+)
+
+func expect(t *testing.T, cases [][2]string) { // 以各种执行方式求值 cases[i][0]，结果或错误应为 cases[i][1]
+	t.Helper()
+	expectIn(t, nil, cases)
+}
+
+func expectIn(t *testing.T, setup func(*Interpreter), cases [][2]string) { // 同 expect，求值前先以 setup 设置解释器
+	t.Helper()
+	for _, c := range cases {
+		for _, engine := range []Engine{TreeWalk, Bytecode, Closure} {
+			in := New()
+			in.Engine = engine
+			if setup != nil {
+				setup(in)
+			}
+			res, err := in.Eval(c[0])
+			got := ToString(res, true)
+			if err != nil {
+				got = fmt.Sprint(err)
+			}
+			if got != c[1] {
+				t.Errorf("engine %v: %v = %v, want %v", engine, c[0], got, c[1])
+			}
+		}
+	}
+}
+
+func TestSetOps(t *testing.T) {
+	expect(t, [][2]string{
+		{"(union %[1] %[2] %[2 3])", "%[1 2 3]"},
+		{"(union %[] %[1] %[1])", "%[1]"},
+		{"(union %[1 2] %[2 3] %[3 4] %[5])", "%[1 2 3 4 5]"},
+		{"(union %[1])", "%[1]"},
+		{"(intersection %[1 2 3] %[2 3] %[3 2 5])", "%[2 3]"},
+		{"(intersection %[1 2] %[2] %[3])", "%[]"},
+		{"(difference %[1 2 3 4] %[2] %[4])", "%[1 3]"},
+		{"(difference %[1 2] %[] %[])", "%[1 2]"},
+		{"(union %[1] (list 1))", "1:13: 类型错误: union 的第2个参数应为集合, 实际为 (1)"},
+	})
+}
+
+func TestMapOps(t *testing.T) {
+	expect(t, [][2]string{
+		{`(get %{1 "a"} 1)`, `"a"`},
+		{`(get %{"k" 1} "x" 0)`, "0"},
+		{`(assoc %{1 "a"} 2 "b" 1 "c")`, `%{1 "c" 2 "b"}`},
+		{`(dissoc %{1 "a" 2 "b"} 1 3)`, `%{2 "b"}`},
+		{`(keys %{"x" 1 'y 2})`, `("x" y)`},
+		{`(vals %{"x" 1 'y 2})`, "(1 2)"},
+		{`(contains? %{nil 1} nil)`, "true"},
+		{`(hash-map 1)`, "1:1: 参数个数错误: hash-map 的参数应为成对的键和值"},
+		{`(hash-map (list 1) 2)`, "1:11: 类型错误: (1) 不能作为键"},
+	})
+}
+
+func TestNumberKeys(t *testing.T) { // 相等的数字是同一个键
+	expect(t, [][2]string{
+		{`(get %{1 "a"} 1.0)`, `"a"`},
+		{`(get %{1.0 "a"} 1)`, `"a"`},
+		{"(contains? %[1] 1.0)", "true"},
+		{"(contains? %[0.5] (/ 1 2))", "true"},
+		{"(contains? %[(/ 4 2)] 2)", "true"},
+		{"(contains? %[100000000000000000000] 100000000000000000000.0)", "true"},
+		{"(hash-set 1 1.0 2)", "%[1 2]"},
+		{"(contains? %[1.5] 1)", "false"},
+		{"(hash-set (/ 0.0 0.0))", "1:1: 类型错误: NaN 不能作为键"},
+		{"(contains? %[1] (/ 0.0 0.0))", "false"},
+	})
+}
+
+func TestEqual(t *testing.T) {
+	expect(t, [][2]string{
+		{"(== 1 1.0)", "true"},
+		{"(== (/ 1 2) 0.5)", "true"},
+		{"(== (list 1 (list 2)) (list 1.0 (list 2)))", "true"},
+		{"(== (list 1 2) (list 1))", "false"},
+		{`(== %{1 "a" 2 "b"} %{2 "b" 1.0 "a"})`, "true"},
+		{"(== %[1 2] %[2 1])", "true"},
+		{"(== %[1 2] %[1])", "false"},
+		{`(== "a" 'a)`, "false"},
+		{`(!= "a" "b")`, "true"},
+	})
+}
@@ -0,0 +1,303 @@
+package lisp
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+)
+
+type Engine int // 执行方式
+
+const (
+	TreeWalk Engine = iota // 遍历语法树求值
+	Bytecode               // 编译为字节码，由虚拟机执行
+	Closure                // 编译为Go闭包，变量按槽位访问
+)
+
+type Interpreter struct { // 解释器，每个解释器拥有独立的全局环境
+	Env    *EnvType  // 全局环境
+	Out    io.Writer // out 的输出位置，默认标准输出
+	Strict bool      // 严格模式：求值未定义的符号时报错，而不是返回符号本身
+	Engine Engine    // 执行方式，默认遍历语法树
+	Cache  bool      // 使用字节码时，将代码文件的编译结果缓存到同目录下的 .lbc 文件
+
+	nodes   map[*Object]*NodePos // 已读入代码的列表节点位置
+	live    int                  // 上次清理后保留的节点位置数
+	depth   int                  // 正在执行的 evalSource 层数，load 时大于1
+	loading []string             // 正在加载的文件，用于检测循环导入
+	modules map[string]*Module   // 已导入的模块，以文件绝对路径为键
+}
+
+func New() *Interpreter { // 创建解释器
+	self := &Interpreter{Out: os.Stdout}
+	self.Reset()
+	return self
+}
+
+func (self *Interpreter) Reset() { // 丢弃全部定义及已导入的模块，恢复刚创建时的全局环境
+	self.Env = NewEnv(self.builtins())
+	self.Env.in = self
+	self.nodes = make(map[*Object]*NodePos)
+	self.live = 0
+	self.modules = make(map[string]*Module)
+}
+
+func (self *Interpreter) Defined() []string { // 全局环境中用户定义的名字，按名排序；内置名字被重新定义为其他值时也列出
+	builtins := self.builtins()
+	var names []string
+	for name, val := range self.Env.vars {
+		if _, ok := builtins[name]; ok {
+			if _, ok := val.(func([]Object) Object); ok || val == nil {
+				continue
+			}
+		}
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (self *Interpreter) Read(src, file string) ([]Object, error) { // 按代码文件规则读取源码中的全部表达式
+	return self.read(SplitSource(src, file))
+}
+
+func (self *Interpreter) ReadBlock(src string) ([]Object, error) { // 按代码块规则读取源码中的全部表达式
+	return self.read([]Source{{src, "", 1, 1, true}})
+}
+
+func (self *Interpreter) read(srcs []Source) ([]Object, error) {
+	var trees []Object
+	for _, s := range srcs {
+		c := Code{Nodes: self.nodes}
+		c.InitSource(s)
+		for _, tree := range c.ReadAll() {
+			if err, ok := tree.(*LispError); ok {
+				return nil, err
+			}
+			trees = append(trees, tree)
+		}
+	}
+	return trees, nil
+}
+
+func (self *Interpreter) Eval(src string) (Object, error) { // 执行源码，返回最后一个表达式的值
+	return self.evalSource(src, "", SplitSource(src, ""))
+}
+
+func (self *Interpreter) EvalBlock(src string) (Object, error) { // 按代码块规则执行源码：表达式可跨行，# 开始行注释
+	return self.evalSource(src, "", []Source{{src, "", 1, 1, true}})
+}
+
+func (self *Interpreter) EvalFile(path string) (Object, error) { // 执行代码文件
+	src, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if abs, err := filepath.Abs(path); err == nil { // 主文件也参与循环导入检测
+		self.loading = append(self.loading, abs)
+		defer func() { self.loading = self.loading[:len(self.loading)-1] }()
+	}
+	return self.evalSource(string(src), path, SplitSource(string(src), path))
+}
+
+func (self *Interpreter) evalSource(src, file string, srcs []Source) (Object, error) {
+	self.depth++
+	defer func() {
+		if self.depth--; self.depth == 0 && len(self.nodes) > 2*self.live+nodesMin { // 位置表比上次清理后翻倍时再清理
+			self.prune()
+		}
+	}()
+	units, err := self.compile(src, file, srcs) // 先读入全部表达式，有语法错误时不执行
+	if err != nil {
+		return nil, err
+	}
+	var res Object
+	for _, run := range units {
+		res = topLevel(run(self.Env))
+		if err, ok := res.(*LispError); ok {
+			return nil, self.locate(err)
+		}
+	}
+	return res, nil
+}
+
+func (self *Interpreter) compile(src, file string, srcs []Source) ([]func(*EnvType) Object, error) { // 按执行方式准备源码中的全部顶层表达式，srcs为源码中的代码片段
+	var units []func(*EnvType) Object
+	if self.Engine == Bytecode {
+		protos, err := self.compileBytecode(src, file, srcs)
+		if err != nil {
+			return nil, err
+		}
+		for _, p := range protos {
+			units = append(units, p.run)
+		}
+		return units, nil
+	}
+	trees, err := self.read(srcs)
+	if err != nil {
+		return nil, err
+	}
+	for _, tree := range trees {
+		tree := tree
+		if self.Engine == Closure {
+			units = append(units, CompileClosure(tree))
+		} else {
+			units = append(units, func(env *EnvType) Object { return Eval(tree, env) })
+		}
+	}
+	return units, nil
+}
+
+func (self *Interpreter) compileBytecode(src, file string, srcs []Source) ([]*Proto, error) { // 编译为字节码，开启缓存时优先读取缓存
+	var cache string
+	if self.Cache && file != "" {
+		cache = file + CacheExt
+		if protos := self.readCache(cache, src, file); protos != nil {
+			return protos, nil
+		}
+	}
+	trees, err := self.read(srcs)
+	if err != nil {
+		return nil, err
+	}
+	protos := make([]*Proto, len(trees))
+	for i, tree := range trees {
+		protos[i] = Compile(tree)
+	}
+	if cache != "" {
+		self.writeCache(cache, src, protos) // 缓存写入失败时不影响执行
+	}
+	return protos, nil
+}
+
+func (self *Interpreter) PosOf(node []Object) (*NodePos, bool) { // 查找列表节点在源码中的位置
+	if len(node) == 0 {
+		return nil, false
+	}
+	np, ok := self.nodes[&node[0]]
+	return np, ok
+}
+
+const nodesMin = 1024 // 节点位置不超过此数时不清理
+
+func (self *Interpreter) prune() { // 执行完毕后丢弃不再可达的节点位置，只保留全局环境及模块中的函数、宏用到的节点
+	live := make(map[*Object]*NodePos)
+	seen := make(map[interface{}]bool) // 已遍历的列表及环境
+	var mark func(x Object)
+	var markEnv func(env *EnvType)
+	mark = func(x Object) {
+		switch v := x.(type) {
+		case []Object:
+			if len(v) == 0 || seen[&v[0]] {
+				return
+			}
+			seen[&v[0]] = true
+			if np, ok := self.nodes[&v[0]]; ok {
+				live[&v[0]] = np
+			}
+			for _, item := range v {
+				mark(item)
+			}
+		case Fn:
+			mark(v.Args)
+			mark(v.Body)
+			markEnv(v.Env)
+		case *HashMap:
+			for _, hk := range v.order {
+				mark(v.entries[hk].Key)
+				mark(v.entries[hk].Val)
+			}
+		case *Set:
+			mark(v.m)
+		case *Module:
+			markEnv(v.Env)
+		}
+	}
+	markEnv = func(env *EnvType) {
+		for ; env != nil && !seen[env]; env = env.up {
+			seen[env] = true
+			for _, val := range env.vars {
+				mark(val)
+			}
+			for _, val := range env.slots {
+				mark(val)
+			}
+		}
+	}
+	markEnv(self.Env)
+	for _, m := range self.modules {
+		markEnv(m.Env)
+	}
+	self.nodes, self.live = live, len(live)
+}
+
+func (self *Interpreter) locate(err *LispError) *LispError { // 根据出错节点补全错误位置
+	if err.Pos.Line > 0 {
+		return err
+	}
+	for _, t := range err.trace { // 取最内层有位置的节点，宏展开生成的节点没有位置
+		if np, ok := self.PosOf(t.node); ok {
+			err.Pos = np.Pos
+			if t.idx >= 0 && t.idx < len(np.Items) {
+				err.Pos = np.Items[t.idx]
+			}
+			break
+		}
+	}
+	return err
+}
+
+func (self *Interpreter) Define(name string, val Object) { // 在全局环境中定义变量/函数，Go值经 FromGo 转换，如 int 转为 int64
+	self.Env.Def(name, FromGo(val))
+}
+
+func (self *Interpreter) Call(name string, args ...Object) (Object, error) { // 调用全局环境中的函数，参数经 FromGo 转换
+	args = append([]Object(nil), args...) // 不修改调用者的切片
+	for i, a := range args {
+		args[i] = FromGo(a)
+	}
+	if !self.Env.Find(name) {
+		return nil, NewError(UnboundSymbol, "未定义的函数 %v", name)
+	}
+	f := self.Env.Get(name)
+	if !IsFunc(f) {
+		return nil, NewError(TypeError, "%v 不是函数", name)
+	}
+	res := Invoke(f, args)
+	if err, ok := res.(*LispError); ok {
+		return nil, self.locate(err)
+	}
+	return res, nil
+}
+
+func (self *Interpreter) CallTo(out interface{}, name string, args ...interface{}) error { // 以Go值为参数调用函数，结果转换后存入out指向的变量，out为nil时忽略结果
+	lt := make([]Object, len(args))
+	for i, a := range args {
+		lt[i] = a
+	}
+	res, err := self.Call(name, lt...)
+	if err != nil || out == nil {
+		return err
+	}
+	return Decode(res, out)
+}
+
+func (self *Interpreter) Bind(name string, fptr interface{}) error { // 将全局环境中的函数包装为Go函数存入fptr，如 var fib func(int) int; in.Bind("fib", &fib)
+	rv := reflect.ValueOf(fptr)
+	if rv.Kind() != reflect.Ptr || rv.Elem().Kind() != reflect.Func {
+		return fmt.Errorf("Bind: fptr 应为函数指针, 实际为 %T", fptr)
+	}
+	f := self.Env.Get(name)
+	if !IsFunc(f) {
+		if !self.Env.Find(name) {
+			return NewError(UnboundSymbol, "未定义的函数 %v", name)
+		}
+		return NewError(TypeError, "%v 不是函数", name)
+	}
+	rv.Elem().Set(makeGoFunc(f, rv.Elem().Type()))
+	return nil
+}
@@ -0,0 +1,31 @@
+package lisp
+
+import "testing"
+
+func TestDefineCall(t *testing.T) { // Define、Call 接受普通的Go值
+	in := New()
+	in.Define("x", 3)
+	in.Define("name", "go")
+	in.Define("xs", []int{1, 2})
+	if _, err := in.Eval(`
+S:
+(fn f [n] {(ret (+ n x))})
+(fn g [s] {(ret (list s name (reduce + 0 xs)))})
+:E
+`); err != nil {
+		t.Fatal(err)
+	}
+	if res, err := in.Eval("(* x 2)"); err != nil || res != int64(6) {
+		t.Fatalf("(* x 2) = %v, %v", res, err)
+	}
+	if res, err := in.Call("f", 5); err != nil || res != int64(8) {
+		t.Fatalf("(f 5) = %v, %v", res, err)
+	}
+	res, err := in.Call("g", "lisp")
+	if got := ToString(res, true); err != nil || got != `("lisp" "go" 3)` {
+		t.Fatalf("(g \"lisp\") = %v, %v", got, err)
+	}
+	if _, err := in.Call("f", "a"); err == nil || err.Error() != "3:20: 类型错误: + 的第1个参数应为数字, 实际为 a" {
+		t.Fatalf("(f \"a\") error = %v", err)
+	}
+}
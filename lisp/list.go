@@ -0,0 +1,140 @@
+package lisp
+
+func listArg(name string, v []Object, i int) ([]Object, *LispError) { // 取出第i个参数作为列表，nil视为空列表
+	if v[i] == nil {
+		return nil, nil
+	}
+	lt, ok := v[i].([]Object)
+	if !ok {
+		err := NewError(TypeError, "%v 的第%v个参数应为列表, 实际为 %v", name, i+1, ToString(v[i], true))
+		err.idx = i + 1
+		return nil, err
+	}
+	return lt, nil
+}
+
+func listBuiltins() map[string]Object { // 列表函数
+	first := func(v []Object) Object {
+		if err := checkArity("first", v, 1, 1); err != nil {
+			return err
+		}
+		lt, err := listArg("first", v, 0)
+		if err != nil {
+			return err
+		}
+		if len(lt) == 0 {
+			return nil
+		}
+		return lt[0]
+	}
+	rest := func(v []Object) Object {
+		if err := checkArity("rest", v, 1, 1); err != nil {
+			return err
+		}
+		lt, err := listArg("rest", v, 0)
+		if err != nil {
+			return err
+		}
+		if len(lt) == 0 {
+			return []Object{}
+		}
+		return lt[1:]
+	}
+	return map[string]Object{
+		"list": func(v []Object) Object { // (list 1 2 3)
+			return append([]Object{}, v...)
+		},
+		"cons": func(v []Object) Object { // (cons x lst) 在列表前加入x
+			if err := checkArity("cons", v, 2, 2); err != nil {
+				return err
+			}
+			lt, err := listArg("cons", v, 1)
+			if err != nil {
+				return err
+			}
+			return append([]Object{v[0]}, lt...)
+		},
+		"car":   first,
+		"first": first,
+		"cdr":   rest,
+		"rest":  rest,
+		"nth": func(v []Object) Object { // (nth lst i) 下标从0开始
+			if err := checkArity("nth", v, 2, 2); err != nil {
+				return err
+			}
+			lt, err := listArg("nth", v, 0)
+			if err != nil {
+				return err
+			}
+			i, ok := v[1].(int64)
+			if !ok {
+				return NewError(TypeError, "nth 的下标应为整数, 实际为 %v", ToString(v[1], true))
+			}
+			if i < 0 || i >= int64(len(lt)) {
+				return NewError(IndexError, "nth 的下标 %v 超出范围 [0, %v)", i, len(lt))
+			}
+			return lt[i]
+		},
+		"len": func(v []Object) Object { // 列表长度，字符串按字符计算，哈希表与集合为元素个数
+			if err := checkArity("len", v, 1, 1); err != nil {
+				return err
+			}
+			switch x := v[0].(type) {
+			case nil:
+				return int64(0)
+			case []Object:
+				return int64(len(x))
+			case Str:
+				return int64(len([]rune(x)))
+			case *HashMap:
+				return int64(x.Len())
+			case *Set:
+				return int64(x.Len())
+			}
+			return NewError(TypeError, "len 的参数应为列表、字符串、哈希表或集合, 实际为 %v", ToString(v[0], true))
+		},
+		"append": func(v []Object) Object { // (append l1 l2 ...) 连接列表
+			res := []Object{}
+			for i := range v {
+				lt, err := listArg("append", v, i)
+				if err != nil {
+					return err
+				}
+				res = append(res, lt...)
+			}
+			return res
+		},
+		"reverse": func(v []Object) Object {
+			if err := checkArity("reverse", v, 1, 1); err != nil {
+				return err
+			}
+			lt, err := listArg("reverse", v, 0)
+			if err != nil {
+				return err
+			}
+			res := make([]Object, len(lt))
+			for i, x := range lt {
+				res[len(lt)-1-i] = x
+			}
+			return res
+		},
+		"empty?": func(v []Object) Object {
+			if err := checkArity("empty?", v, 1, 1); err != nil {
+				return err
+			}
+			switch x := v[0].(type) {
+			case nil:
+				return true
+			case []Object:
+				return len(x) == 0
+			case Str:
+				return len(x) == 0
+			case *HashMap:
+				return x.Len() == 0
+			case *Set:
+				return x.Len() == 0
+			}
+			return NewError(TypeError, "empty? 的参数应为列表、字符串、哈希表或集合, 实际为 %v", ToString(v[0], true))
+		},
+	}
+}
@@ -0,0 +1,269 @@
+package lisp
+
+import (
+	"math"
+	"math/big"
+)
+
+// 遍历循环：(for-each [x coll] {...}) 依次以集合的元素绑定x，(dotimes [i n] {...}) 依次以0到n-1绑定i
+// 每次循环在新的内环境中绑定循环变量，循环体中可以使用 ret、break、continue，带标签时为 (for-each label [x coll] {...})
+// (for-each f lst) 是调用 for-each 函数，见 isEachForm
+
+type eachForm struct {
+	node  []Object
+	op    string
+	label string
+	bi    int       // 绑定 [x coll] 的下标
+	names []*Symbol // 循环变量，有多个时把元素拆开绑定，如哈希表的 [k v m]
+	coll  Object    // 集合或次数的表达式
+	body  []Object
+}
+
+func isEachForm(v []Object) bool { // 是否为遍历循环：第一个参数为绑定 [x coll]，即除最后一项外都是符号，且其后为由列表组成的语句块
+	switch v[0].(*Symbol).Name {
+	case "dotimes":
+		return true
+	case "for-each":
+		switch len(v) {
+		case 4: // 带标签，for-each 函数只有两个参数
+			return true
+		case 3:
+		default:
+			return false
+		}
+		binds, ok := v[1].([]Object)
+		if !ok || len(binds) < 2 {
+			return false
+		}
+		for _, b := range binds[:len(binds)-1] {
+			if _, ok := b.(*Symbol); !ok {
+				return false
+			}
+		}
+		body, ok := v[2].([]Object)
+		if !ok {
+			return false
+		}
+		for _, e := range body { // 语句块中的每条语句都是列表，而 (f x) 形式的调用以函数开头
+			if _, ok := e.([]Object); !ok {
+				return false
+			}
+		}
+		return true
+	}
+	return false
+}
+
+func parseEach(v []Object) (*eachForm, *LispError) { // 检查遍历循环的结构
+	f := &eachForm{node: v, op: v[0].(*Symbol).Name, bi: 1}
+	form := "[x coll]"
+	if f.op == "dotimes" {
+		form = "[i n]"
+	}
+	syntax := func(i int, format string, a ...interface{}) *LispError {
+		err := NewError(SyntaxError, format, a...)
+		err.idx = i
+		return err
+	}
+	switch len(v) {
+	case 3:
+	case 4:
+		sym, ok := v[1].(*Symbol)
+		if !ok {
+			return nil, syntax(1, "%v 的标签应为符号, 实际为 %v", f.op, v[1])
+		}
+		f.label, f.bi = sym.Name, 2
+	default:
+		return nil, syntax(-1, "%v 结构错误！正确格式为：(%v %v {expr1 expr2 ...})", f.op, f.op, form)
+	}
+	binds, ok := v[f.bi].([]Object)
+	if !ok || len(binds) < 2 || (f.op == "dotimes" && len(binds) != 2) {
+		return nil, syntax(f.bi, "%v 的绑定应为 %v", f.op, form)
+	}
+	for _, b := range binds[:len(binds)-1] {
+		sym, ok := b.(*Symbol)
+		if !ok {
+			return nil, syntax(f.bi, "%v 的循环变量应为符号, 实际为 %v", f.op, b)
+		}
+		f.names = append(f.names, sym)
+	}
+	f.coll = binds[len(binds)-1]
+	if f.body, ok = v[f.bi+1].([]Object); !ok {
+		return nil, syntax(f.bi+1, "%v 的循环体应为列表 {expr1 expr2 ...}", f.op)
+	}
+	return f, nil
+}
+
+func eachItems(op string, coll Object) (int, func(int) Object, *LispError) { // 循环次数及第i次的元素
+	if op == "dotimes" {
+		n, ok := count(coll)
+		if !ok {
+			return 0, nil, NewError(TypeError, "dotimes 的次数应为整数, 实际为 %v", ToString(coll, true))
+		}
+		return n, func(i int) Object { return int64(i) }, nil
+	}
+	seq, err := seqArg(op, []Object{coll}, 0)
+	if err != nil {
+		return 0, nil, NewError(TypeError, "for-each 只能遍历列表、字符串、集合或哈希表, 实际为 %v", ToString(coll, true))
+	}
+	return len(seq), func(i int) Object { return seq[i] }, nil
+}
+
+func splitItem(op string, item Object, n int) ([]Object, *LispError) { // 把元素拆开绑定到n个变量
+	parts, ok := item.([]Object)
+	if !ok || len(parts) != n {
+		return nil, NewError(TypeError, "%v 的元素 %v 不能拆分为%v个变量", op, ToString(item, true), n)
+	}
+	return parts, nil
+}
+
+func count(x Object) (int, bool) { // dotimes 的次数，可为任意整数值的数字，负数时为0
+	switch n := x.(type) {
+	case int64:
+		return int(n), true
+	case *big.Int: // 超出int64范围
+		if n.Sign() < 0 {
+			return 0, true
+		}
+		return math.MaxInt, true
+	case float64:
+		switch {
+		case n != math.Trunc(n) || math.IsInf(n, 0):
+			return 0, false
+		case n < 0:
+			return 0, true
+		case n >= math.MaxInt:
+			return math.MaxInt, true
+		}
+		return int(n), true
+	}
+	return 0, false
+}
+
+func (self *eachForm) bind(env *EnvType, slots []int, item Object) *LispError { // 在本层绑定循环变量，slots为编译时分配的槽位
+	if len(self.names) == 1 {
+		env.define(slots[0], self.names[0].Name, item)
+		return nil
+	}
+	parts, err := splitItem(self.op, item, len(self.names))
+	if err != nil {
+		return err.at(self.node, self.bi)
+	}
+	for i, name := range self.names {
+		env.define(slots[i], name.Name, parts[i])
+	}
+	return nil
+}
+
+func (self *eachForm) run(env *EnvType, coll Object, sc *scope, slots []int, body closure) Object { // 执行循环，每次在新的内环境中绑定循环变量
+	n, item, err := eachItems(self.op, coll)
+	if err != nil {
+		return err.at(self.node, self.bi)
+	}
+	for i := 0; i < n; i++ {
+		each_env := env.Copy()
+		if sc != nil {
+			each_env.frame(sc)
+		}
+		if err := self.bind(each_env, slots, item(i)); err != nil {
+			return err
+		}
+		if res := body(each_env); res != nil {
+			if ctl, ok := loopSignal(res, self.label); ok {
+				if ctl.brk {
+					break
+				}
+				continue
+			}
+			return res
+		}
+	}
+	return nil
+}
+
+func evalEach(v []Object, env *EnvType) Object {
+	f, err := parseEach(v)
+	if err != nil {
+		return err
+	}
+	coll := Eval(f.coll, env)
+	if IsError(coll) {
+		return coll
+	}
+	slots := make([]int, len(f.names))
+	for i := range slots {
+		slots[i] = -1
+	}
+	return f.run(env, coll, nil, slots, func(each_env *EnvType) Object {
+		return evalBlock(f.body, each_env)
+	})
+}
+
+func (self *closureCompiler) each(v []Object, sc *scope) closure {
+	f, err := parseEach(v)
+	if err != nil {
+		return func(*EnvType) Object {
+			_, err := parseEach(v) // 每次报告新的错误值
+			return err
+		}
+	}
+	coll := self.expr(f.coll, sc)
+	es := &scope{up: sc}
+	slots := make([]int, len(f.names))
+	for i, name := range f.names {
+		slots[i] = es.declare(name.Name)
+	}
+	body := self.block(f.body, es)
+	return func(env *EnvType) Object {
+		c := coll(env)
+		if IsError(c) {
+			return c
+		}
+		return f.run(env, c, es, slots, body)
+	}
+}
+
+type iterState struct { // 字节码遍历循环的迭代器，只在虚拟机的栈上
+	n    int
+	item func(int) Object
+	i    int
+}
+
+func (self *Proto) each(v []Object) { // 编译遍历循环，每次循环进入新的语句块绑定循环变量
+	f, err := parseEach(v)
+	if err != nil {
+		self.raise(err, v, err.idx)
+		return
+	}
+	self.expr(f.coll, v, -1)
+	op := self.konst(Str(f.op))
+	self.emit(opIter, op, v, f.bi)
+	start := self.here()
+	end_pc := self.emit(opNext, 0, nil, -1)
+	self.emit(opEnter, 0, nil, -1)
+	if len(f.names) > 1 {
+		pc := self.emit(opUnpack, int32(len(f.names)), v, f.bi)
+		self.code[pc].B = op
+	}
+	for i := len(f.names) - 1; i >= 0; i-- { // 拆开的值按顺序在栈上，从最后一个开始绑定
+		self.emit(opDef, self.konst(f.names[i]), nil, -1)
+		self.emit(opPop, 0, nil, -1)
+	}
+	var exits []int
+	for _, e := range f.body { // 循环体中遇到Return时结束本次循环
+		self.expr(e, v, -1)
+		exits = append(exits, self.emit(opStmt, 0, nil, -1))
+	}
+	self.emit(opLeave, 0, nil, -1)
+	self.emit(opJump, start, nil, -1)
+	self.patchAll(exits)
+	self.emit(opLeave, 0, nil, -1)
+	pc := self.emit(opLoop, start, nil, -1) // continue 时回到开头，break 时结果为nil
+	self.code[pc].B = self.konst(Str(f.label))
+	self.emit(opNip, 0, nil, -1) // 弹出迭代器
+	done_pc := self.emit(opJump, 0, nil, -1)
+	self.patch(end_pc)
+	self.emit(opPop, 0, nil, -1)
+	self.emit(opConst, self.konst(nil), nil, -1)
+	self.patch(done_pc)
+}
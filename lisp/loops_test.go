@@ -0,0 +1,23 @@
+package lisp
+
+import "testing"
+
+func TestEach(t *testing.T) {
+	expect(t, [][2]string{
+		{"(set r (list))\n(for-each [c \"中a\"] {(set r (cons c r))})\n(val r)", `("a" "中")`},
+		{"(set s 0)\n(for-each [k v %{1 2 3 4}] {(set s (+ s (* k v)))})\n(val s)", "14"},
+		{"(set s 0)\n(for-each [i (range 10 0 -3)] {(set s (+ s i))})\n(val s)", "22"},
+		{"(set s 0)\n(fn add [x] {(set! s (+ s x))})\n(set id (lambda [f] {(ret f)}))\n(for-each (id add) ((id list) 1 2))\n(val s)", "3"},
+		{"(for-each [x 5] {})", "1:11: 类型错误: for-each 只能遍历列表、字符串、集合或哈希表, 实际为 5"},
+		{"(for-each outer x {})", "1:17: 语法错误: for-each 的绑定应为 [x coll]"},
+		{"(for-each outer [1 x] {})", "1:17: 语法错误: for-each 的循环变量应为符号, 实际为 1"},
+		{"(set r (list))\n(set x (list 1 2))\n(for-each [y x] {(set r (cons y r))})\n(val r)", "(2 1)"},
+		{"(set x 1)\n(for-each [x (list 1)] (list 2))", "2:12: 类型错误: x 不是函数"},
+		{"(set n 0)\n(dotimes [i 3.0] {(set n (+ n i))})\n(val n)", "3"},
+		{"(set n 0)\n(dotimes [i (- 0 100000000000000000000)] {(set n 1)})\n(val n)", "0"},
+		{"(set n 0)\n(dotimes [i -2.0] {(set n 1)})\n(val n)", "0"},
+		{"(set n 0)\n(dotimes [i 100000000000000000000] {(set n i) (if (== i 2) {(break)})})\n(val n)", "2"},
+		{"(dotimes [i 2.5] {})", "1:10: 类型错误: dotimes 的次数应为整数, 实际为 2.5"},
+		{"(dotimes [i (/ 1 2)] {})", "1:10: 类型错误: dotimes 的次数应为整数, 实际为 1/2"},
+	})
+}
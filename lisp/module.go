@@ -0,0 +1,132 @@
+package lisp
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const PathEnv = "LISP_PATH" // 模块搜索路径的环境变量，多个路径以系统的路径分隔符分隔
+
+type Module struct { // 由 import 导入的模块，通过 name/x 访问其中的变量和函数
+	Name string
+	Path string   // 文件绝对路径
+	Env  *EnvType // 模块环境，外层为全局环境
+}
+
+func (self *Module) String() string {
+	return "<module " + self.Name + ">"
+}
+
+func (self *Interpreter) findFile(path, from string) (string, *LispError) { // 查找要加载的文件：先相对于from所在目录，再依次在搜索路径中查找
+	dirs := []string{"."}
+	if from != "" {
+		dirs[0] = filepath.Dir(from)
+	}
+	if filepath.IsAbs(path) {
+		dirs = []string{""}
+	} else {
+		dirs = append(dirs, filepath.SplitList(os.Getenv(PathEnv))...)
+	}
+	for _, dir := range dirs {
+		p := filepath.Join(dir, path)
+		if info, err := os.Stat(p); err == nil && !info.IsDir() {
+			return p, nil
+		}
+	}
+	return "", NewError(ImportError, "找不到文件 %v", path)
+}
+
+func (self *Interpreter) loadFile(path string, env *EnvType) Object { // 在env中执行文件，返回最后一个表达式的值
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return NewError(ImportError, "%v", err)
+	}
+	for i, p := range self.loading {
+		if p == abs {
+			chain := append(append([]string{}, self.loading[i:]...), abs)
+			for j := range chain {
+				chain[j] = filepath.Base(chain[j])
+			}
+			return NewError(ImportError, "循环导入 %v", strings.Join(chain, " -> "))
+		}
+	}
+	src, err := ioutil.ReadFile(path)
+	if err != nil {
+		return NewError(ImportError, "%v", err)
+	}
+	units, err := self.compile(string(src), path, SplitSource(string(src), path))
+	if err != nil {
+		return err.(*LispError)
+	}
+	self.loading = append(self.loading, abs)
+	defer func() { self.loading = self.loading[:len(self.loading)-1] }()
+	var res Object
+	for _, run := range units {
+		if res = topLevel(run(env)); IsError(res) {
+			return res
+		}
+	}
+	return res
+}
+
+func (self *Interpreter) importFile(path, name string) Object { // 导入模块，同一文件只执行一次
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return NewError(ImportError, "%v", err)
+	}
+	if m, ok := self.modules[abs]; ok {
+		return m
+	}
+	m := &Module{Name: name, Path: abs}
+	m.Env = self.Env.FnCopy() // 模块中定义的变量和函数在模块自己的帧中
+	if res := self.loadFile(path, m.Env); IsError(res) {
+		return res
+	}
+	self.modules[abs] = m
+	return m
+}
+
+func evalLoad(v []Object, env *EnvType) Object { // (load "path") 与 (import "path" as name)
+	op := v[0].(*Symbol).Name
+	if op == "load" && len(v) != 2 || op == "import" && len(v) != 2 && len(v) != 4 {
+		return NewError(SyntaxError, "%v 结构错误！正确格式为：(load \"path\") 或 (import \"path\" as name)", op)
+	}
+	in := env.in
+	if in == nil {
+		return NewError(ImportError, "%v 需要在解释器中执行", op)
+	}
+	x := Eval(v[1], env)
+	if IsError(x) { // 路径表达式出错时原样返回
+		return x
+	}
+	path, ok := x.(Str)
+	if !ok {
+		return NewError(TypeError, "%v 的路径应为字符串", op).at(v, 1)
+	}
+	var from string // 当前代码所在的文件
+	if np, ok := in.PosOf(v); ok {
+		from = np.Pos.File
+	}
+	file, err := in.findFile(string(path), from)
+	if err != nil {
+		return err.at(v, 1)
+	}
+	if op == "load" {
+		return in.loadFile(file, env)
+	}
+	name := strings.TrimSuffix(filepath.Base(file), filepath.Ext(file)) // 默认以文件名为模块名
+	if len(v) == 4 {
+		as, ok := v[3].(*Symbol)
+		if v[2] != Sym("as") || !ok {
+			return NewError(SyntaxError, "import 结构错误！正确格式为：(import \"path\" as name)").at(v, 2)
+		}
+		name = as.Name
+	}
+	res := in.importFile(file, name)
+	if m, ok := res.(*Module); ok {
+		env.Set(name, m)
+	}
+	return res
+}
@@ -0,0 +1,86 @@
+package lisp
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLoadPath(t *testing.T) {
+	expect(t, [][2]string{
+		{`(load (+ 1 "a"))`, "1:12: 类型错误: + 的第2个参数应为数字, 实际为 a"},
+		{`(import (throw "x") as m)`, "1:9: 用户错误: x"},
+		{`(load 1)`, "1:7: 类型错误: load 的路径应为字符串"},
+		{`(import nosuch)`, "1:9: 类型错误: import 的路径应为字符串"},
+	})
+}
+
+func writeFiles(t *testing.T, dir string, files map[string]string) { // 在dir下写入测试用的代码文件
+	for name, src := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(src), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestModules(t *testing.T) {
+	dir, lib := t.TempDir(), t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"main.txt":        "(import \"util/math.txt\" as m)\n(import \"util/math.txt\")\n(load \"util/vars.txt\")\n(import \"shared.txt\")\n(out (m/double 21) (math/double 1) m/pi base shared/name)",
+		"util/math.txt":   "(out \"loading math\")\n(load \"helper.txt\")\n(fn double [x] {(ret (* 2 (twice-id x)))})\n(set pi 3)",
+		"util/helper.txt": "(fn twice-id [x] {(ret x)})",
+		"util/vars.txt":   "(set base 10)",
+		"cycle/a.txt":     "(import \"b.txt\")",
+		"cycle/b.txt":     "(import \"c.txt\")",
+		"cycle/c.txt":     "(import \"a.txt\")",
+		"missing.txt":     "(load \"nosuch.txt\")",
+		"private.txt":     "(import \"util/math.txt\" as m)\n(twice-id 1)",
+		"bad.txt":         "(import \"util/math.txt\" with m)",
+		"fails.txt":       "(import \"util/broken.txt\")",
+		"util/broken.txt": "(set a 1)\n(+ a \"x\")",
+	})
+	writeFiles(t, lib, map[string]string{"shared.txt": "(set name \"lib\")"})
+	t.Setenv(PathEnv, lib)
+	cases := []struct {
+		file, out string
+		kind      ErrKind
+	}{
+		{"main.txt", "loading math\n42 2 3 10 lib\n", -1}, // 同一文件只执行一次，load 在当前环境中执行
+		{"cycle/a.txt", "", ImportError},
+		{"missing.txt", "", ImportError},
+		{"private.txt", "loading math\n", UnboundSymbol}, // 模块中的定义不进入全局环境
+		{"bad.txt", "", SyntaxError},
+		{"fails.txt", "", TypeError},
+	}
+	for _, engine := range []Engine{TreeWalk, Bytecode, Closure} {
+		for _, c := range cases {
+			var out bytes.Buffer
+			in := New()
+			in.Out = &out
+			in.Engine = engine
+			in.Strict = true
+			_, err := in.EvalFile(filepath.Join(dir, c.file))
+			kind := ErrKind(-1)
+			if e, ok := err.(*LispError); ok {
+				kind = e.Kind
+			} else if err != nil {
+				t.Fatalf("%v: %v", c.file, err)
+			}
+			if out.String() != c.out || kind != c.kind {
+				t.Errorf("engine %v: %v = %q, %v", engine, c.file, out.String(), err)
+			}
+		}
+	}
+	in := New()
+	_, err := in.EvalFile(filepath.Join(dir, "cycle/a.txt"))
+	if want := fmt.Sprintf("%v:1:1: 导入错误: 循环导入 a.txt -> b.txt -> c.txt -> a.txt", filepath.Join(dir, "cycle/c.txt")); fmt.Sprint(err) != want {
+		t.Errorf("cycle = %v, want %v", err, want)
+	}
+}
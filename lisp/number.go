@@ -0,0 +1,281 @@
+package lisp
+
+import (
+	"math"
+	"math/big"
+	"strconv"
+	"strings"
+)
+
+// 数字类型由低到高：int64 -> *big.Int -> *big.Rat -> float64
+// 运算时两个操作数提升到较高的一级，结果再化简到能精确表示的最低一级
+const (
+	numInt = iota
+	numBig
+	numRat
+	numFloat
+)
+
+func numLevel(v Object) int { // 数字级别，非数字返回-1
+	switch v.(type) {
+	case int64:
+		return numInt
+	case *big.Int:
+		return numBig
+	case *big.Rat:
+		return numRat
+	case float64:
+		return numFloat
+	}
+	return -1
+}
+
+func ParseNumber(s string) Object { // 解析数字字面量，含小数点或指数为float64，a/b 为分数，否则为整数，无效时返回nil
+	if strings.Contains(s, "/") {
+		parts := strings.Split(s, "/")
+		if len(parts) != 2 || strings.ContainsAny(s, ".eE") || strings.HasPrefix(parts[1], "-") {
+			return nil
+		}
+		if r, ok := new(big.Rat).SetString(s); ok {
+			return normRat(r)
+		}
+		return nil
+	}
+	if strings.ContainsAny(s, ".eE") {
+		if f, err := strconv.ParseFloat(s, 64); err == nil {
+			return f
+		}
+		return nil
+	}
+	if n, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return n
+	}
+	if n, ok := new(big.Int).SetString(s, 10); ok { // 超出int64范围
+		return n
+	}
+	return nil
+}
+
+func IsNumber(v Object) bool { // 判断是否为数字
+	return numLevel(v) >= 0
+}
+
+func toBig(v Object) *big.Int {
+	switch n := v.(type) {
+	case int64:
+		return big.NewInt(n)
+	case *big.Int:
+		return n
+	}
+	return nil
+}
+
+func toRat(v Object) *big.Rat {
+	switch n := v.(type) {
+	case int64:
+		return new(big.Rat).SetInt64(n)
+	case *big.Int:
+		return new(big.Rat).SetInt(n)
+	case *big.Rat:
+		return n
+	}
+	return nil
+}
+
+func ToFloat(v Object) float64 { // 数字转为float64
+	switch n := v.(type) {
+	case int64:
+		return float64(n)
+	case *big.Int:
+		f, _ := new(big.Float).SetInt(n).Float64()
+		return f
+	case *big.Rat:
+		f, _ := n.Float64()
+		return f
+	case float64:
+		return n
+	}
+	return math.NaN()
+}
+
+func exactRat(v Object) *big.Rat { // 数字的精确值，浮点数须为有限值
+	if f, ok := v.(float64); ok {
+		return new(big.Rat).SetFloat64(f)
+	}
+	return toRat(v)
+}
+
+func isNaN(v Object) bool {
+	f, ok := v.(float64)
+	return ok && f != f
+}
+
+func normBig(n *big.Int) Object { // 能用int64表示时化简为int64
+	if n.IsInt64() {
+		return n.Int64()
+	}
+	return n
+}
+
+func normRat(r *big.Rat) Object { // 分母为1时化简为整数
+	if r.IsInt() {
+		return normBig(new(big.Int).Set(r.Num()))
+	}
+	return r
+}
+
+func level2(a, b Object) int {
+	la, lb := numLevel(a), numLevel(b)
+	if la > lb {
+		return la
+	}
+	return lb
+}
+
+func NumAdd(a, b Object) Object { // a+b
+	switch level2(a, b) {
+	case numInt:
+		x, y := a.(int64), b.(int64)
+		if (y > 0 && x > math.MaxInt64-y) || (y < 0 && x < math.MinInt64-y) { // 溢出时提升为大整数
+			return new(big.Int).Add(big.NewInt(x), big.NewInt(y))
+		}
+		return x + y
+	case numBig:
+		return normBig(new(big.Int).Add(toBig(a), toBig(b)))
+	case numRat:
+		return normRat(new(big.Rat).Add(toRat(a), toRat(b)))
+	}
+	return ToFloat(a) + ToFloat(b)
+}
+
+func NumSub(a, b Object) Object { // a-b
+	switch level2(a, b) {
+	case numInt:
+		x, y := a.(int64), b.(int64)
+		if (y < 0 && x > math.MaxInt64+y) || (y > 0 && x < math.MinInt64+y) {
+			return new(big.Int).Sub(big.NewInt(x), big.NewInt(y))
+		}
+		return x - y
+	case numBig:
+		return normBig(new(big.Int).Sub(toBig(a), toBig(b)))
+	case numRat:
+		return normRat(new(big.Rat).Sub(toRat(a), toRat(b)))
+	}
+	return ToFloat(a) - ToFloat(b)
+}
+
+func NumMul(a, b Object) Object { // a*b
+	switch level2(a, b) {
+	case numInt:
+		x, y := a.(int64), b.(int64)
+		if x == 0 || y == 0 {
+			return int64(0)
+		}
+		z := x * y
+		if z/y != x || (x == -1 && y == math.MinInt64) || (y == -1 && x == math.MinInt64) {
+			return new(big.Int).Mul(big.NewInt(x), big.NewInt(y))
+		}
+		return z
+	case numBig:
+		return normBig(new(big.Int).Mul(toBig(a), toBig(b)))
+	case numRat:
+		return normRat(new(big.Rat).Mul(toRat(a), toRat(b)))
+	}
+	return ToFloat(a) * ToFloat(b)
+}
+
+func NumDiv(a, b Object) Object { // a/b，整数相除得到精确的分数
+	if level2(a, b) == numFloat {
+		return ToFloat(a) / ToFloat(b)
+	}
+	y := toRat(b)
+	if y.Sign() == 0 {
+		return NewError(ArithError, "除数为0")
+	}
+	return normRat(new(big.Rat).Quo(toRat(a), y))
+}
+
+func NumRem(a, b Object) Object { // a除以b的余数，符号与a相同
+	switch level2(a, b) {
+	case numInt, numBig:
+		y := toBig(b)
+		if y.Sign() == 0 {
+			return NewError(ArithError, "除数为0")
+		}
+		x, ok1 := a.(int64)
+		z, ok2 := b.(int64)
+		if ok1 && ok2 {
+			return x % z
+		}
+		return normBig(new(big.Int).Rem(toBig(a), y))
+	case numRat:
+		x, y := toRat(a), toRat(b)
+		if y.Sign() == 0 {
+			return NewError(ArithError, "除数为0")
+		}
+		q := new(big.Rat).Quo(x, y)
+		t := new(big.Int).Quo(q.Num(), q.Denom()) // 向0取整
+		return normRat(new(big.Rat).Sub(x, new(big.Rat).Mul(y, new(big.Rat).SetInt(t))))
+	}
+	return math.Mod(ToFloat(a), ToFloat(b))
+}
+
+const maxPowBits = 1 << 24 // 精确的幂最多的二进制位数
+
+func NumPow(a, b Object) Object { // a的b次方，整数次幂保持精确
+	if n, ok := b.(int64); ok && numLevel(a) <= numRat {
+		neg := n < 0
+		e := new(big.Int).Abs(big.NewInt(n)) // -MinInt64 超出int64范围
+		q := toRat(a)
+		if bits := q.Num().BitLen() + q.Denom().BitLen(); bits > 2 && float64(bits)*math.Abs(float64(n)) > maxPowBits { // 底数为0、1、-1时结果很小
+			return NewError(ArithError, "%v 的 %v 次方结果过大", ToString(a, true), n)
+		}
+		var r *big.Rat
+		if x := toBig(a); x != nil {
+			r = new(big.Rat).SetInt(new(big.Int).Exp(x, e, nil))
+		} else {
+			num := new(big.Int).Exp(q.Num(), e, nil)
+			den := new(big.Int).Exp(q.Denom(), e, nil)
+			r = new(big.Rat).SetFrac(num, den)
+		}
+		if neg {
+			if r.Sign() == 0 {
+				return NewError(ArithError, "除数为0")
+			}
+			r.Inv(r)
+		}
+		return normRat(r)
+	}
+	return math.Pow(ToFloat(a), ToFloat(b))
+}
+
+func NumCmp(a, b Object) int { // 比较大小，返回-1、0、1；有NaN时结果无意义，调用前应先用 isNaN 检查
+	switch level2(a, b) {
+	case numInt:
+		x, y := a.(int64), b.(int64)
+		switch {
+		case x < y:
+			return -1
+		case x > y:
+			return 1
+		}
+		return 0
+	case numBig:
+		return toBig(a).Cmp(toBig(b))
+	case numRat:
+		return toRat(a).Cmp(toRat(b))
+	}
+	x, y := ToFloat(a), ToFloat(b)
+	_, fa := a.(float64)
+	_, fb := b.(float64)
+	if !(fa && fb) && !math.IsInf(x, 0) && !math.IsInf(y, 0) && !math.IsNaN(x) && !math.IsNaN(y) { // 整数与有限的浮点数精确比较，大于2^53的整数转为浮点数会丢失精度
+		return exactRat(a).Cmp(exactRat(b))
+	}
+	switch {
+	case x < y:
+		return -1
+	case x > y:
+		return 1
+	}
+	return 0
+}
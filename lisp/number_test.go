@@ -0,0 +1,36 @@
+package lisp
+
+import "testing"
+
+func TestPow(t *testing.T) {
+	expect(t, [][2]string{
+		{"(^ 2 10)", "1024"},
+		{"(^ 2 -2)", "1/4"},
+		{"(^ 2/3 3)", "8/27"},
+		{"(^ 2 100)", "1267650600228229401496703205376"},
+		{"(^ 4 0.5)", "2.0"},
+		{"(^ 1 -9223372036854775808)", "1"},
+		{"(^ -1 -9223372036854775807)", "-1"},
+		{"(^ 0 -1)", "1:1: 算术错误: 除数为0"},
+		{"(^ 2 -9223372036854775808)", "1:1: 算术错误: 2 的 -9223372036854775808 次方结果过大"},
+		{"(^ 2 9223372036854775807)", "1:1: 算术错误: 2 的 9223372036854775807 次方结果过大"},
+	})
+}
+
+func TestNumCmp(t *testing.T) { // 整数与浮点数精确比较，与哈希表的键一致
+	expect(t, [][2]string{
+		{"(== 9007199254740993 9007199254740992.0)", "false"},
+		{"(contains? %[9007199254740993] 9007199254740992.0)", "false"},
+		{"(== 9007199254740992 9007199254740992.0)", "true"},
+		{"(contains? %[9007199254740992] 9007199254740992.0)", "true"},
+		{"(> 9007199254740993 9007199254740992.0)", "true"},
+		{"(< 100000000000000000001 100000000000000000000.0)", "false"},
+		{"(== 100000000000000000001 100000000000000000000.0)", "false"},
+		{"(== (/ 1 3) 0.3333333333333333)", "false"},
+		{"(== (/ 1 2) 0.5)", "true"},
+		{"(< 100000000000000000000 (/ 1.0 0.0))", "true"},
+		{"(== (/ 0.0 0.0) (/ 0.0 0.0))", "false"},
+		{"(== (/ 0.0 0.0) 1)", "false"},
+		{"(>= (/ 0.0 0.0) 1)", "false"},
+	})
+}
@@ -0,0 +1,172 @@
+package lisp
+
+// 形参列表：[a b] 必需参数；[a (b 10)] b 省略时为默认值；[a b & rest] 其余实参组成列表 rest；
+// [a &key (sep " ") end] 命名参数，调用时写作 (f 1 :sep ", " :end "!")，未传入时为默认值或nil
+// 默认值在调用时于函数环境中求值，可以引用前面的参数
+
+type param struct {
+	name string
+	def  Object // 默认值表达式，没有时为nil
+}
+
+type signature struct {
+	params []param // 位置参数，前 min 个为必需参数
+	min    int
+	rest   string  // 变长参数名，没有时为空
+	keys   []param // 命名参数
+}
+
+func parseParams(desc string, args []Object) (*signature, *LispError) { // 检查形参列表，desc为报错时的函数名
+	sig := &signature{}
+	keys := false // 是否已出现 &key
+	for i := 0; i < len(args); i++ {
+		switch x := args[i].(type) {
+		case *Symbol:
+			switch {
+			case x.Name == "&":
+				if keys || i+2 != len(args) {
+					return nil, NewError(SyntaxError, "%v 的 & 之后应为最后一个形参，且不能与 &key 同时使用", desc)
+				}
+				rest, ok := args[i+1].(*Symbol)
+				if !ok {
+					return nil, NewError(SyntaxError, "%v 的形参应为符号, 实际为 %v", desc, args[i+1])
+				}
+				sig.rest = rest.Name
+				i++ // rest 是最后一个形参
+			case x.Name == "&key":
+				if keys {
+					return nil, NewError(SyntaxError, "%v 的形参中只能有一个 &key", desc)
+				}
+				keys = true
+			case x.IsKeyword():
+				return nil, NewError(SyntaxError, "%v 的形参不能是关键字 %v", desc, x)
+			case keys:
+				sig.keys = append(sig.keys, param{name: x.Name})
+			case len(sig.params) > sig.min: // 必需参数在前
+				return nil, NewError(SyntaxError, "%v 的参数 %v 应在有默认值的参数之前", desc, x)
+			default:
+				sig.params = append(sig.params, param{name: x.Name})
+				sig.min++
+			}
+		case []Object:
+			var name *Symbol
+			if len(x) == 2 {
+				name, _ = x[0].(*Symbol)
+			}
+			if name == nil || name.IsKeyword() || name.Name == "&" || name.Name == "&key" {
+				return nil, NewError(SyntaxError, "%v 的默认参数应为 (name expr), 实际为 %v", desc, ToString(x, true))
+			}
+			if keys {
+				sig.keys = append(sig.keys, param{name.Name, x[1]})
+			} else {
+				sig.params = append(sig.params, param{name.Name, x[1]})
+			}
+		default:
+			return nil, NewError(SyntaxError, "%v 的形参应为符号, 实际为 %v", desc, x)
+		}
+	}
+	seen := map[string]bool{}
+	for _, name := range sig.names() {
+		if seen[name] {
+			return nil, NewError(SyntaxError, "%v 的形参 %v 重复", desc, name)
+		}
+		seen[name] = true
+	}
+	return sig, nil
+}
+
+func (self *signature) names() []string { // 所有形参名
+	var res []string
+	for _, p := range self.params {
+		res = append(res, p.name)
+	}
+	if self.rest != "" {
+		res = append(res, self.rest)
+	}
+	for _, p := range self.keys {
+		res = append(res, p.name)
+	}
+	return res
+}
+
+func (self *signature) bind(desc string, env *EnvType, args []Object) *LispError { // 在函数环境中绑定实参
+	if self.rest == "" && self.keys == nil && self.min == len(self.params) { // 只有必需参数
+		if len(args) != self.min {
+			return checkArity(desc, args, self.min, self.min)
+		}
+		for i, p := range self.params {
+			env.Def(p.name, args[i])
+		}
+		return nil
+	}
+	pos, named := args, []Object(nil)
+	if self.keys != nil { // 第一个关键字之后为命名参数
+		for i, arg := range args {
+			if sym, ok := arg.(*Symbol); ok && sym.IsKeyword() {
+				pos, named = args[:i], args[i:]
+				break
+			}
+		}
+	}
+	max := len(self.params)
+	if self.rest != "" {
+		max = -1
+	}
+	if err := checkArity(desc, pos, self.min, max); err != nil {
+		return err
+	}
+	for i, p := range self.params {
+		if i < len(pos) {
+			env.Def(p.name, pos[i])
+		} else if err := bindDefault(env, p); err != nil {
+			return err
+		}
+	}
+	if self.rest != "" {
+		rest := []Object{}
+		if len(pos) > len(self.params) {
+			rest = append(rest, pos[len(self.params):]...)
+		}
+		env.Def(self.rest, rest)
+	}
+	if self.keys == nil {
+		return nil
+	}
+	if len(named)%2 != 0 {
+		return NewError(ArityError, "%v 的命名参数应成对传入 :name value", desc)
+	}
+	given := map[string]Object{}
+	for i := 0; i < len(named); i += 2 {
+		sym, ok := named[i].(*Symbol)
+		if !ok || !sym.IsKeyword() || !self.hasKey(sym.Name[1:]) {
+			return NewError(ArityError, "%v 没有命名参数 %v", desc, ToString(named[i], true))
+		}
+		given[sym.Name[1:]] = named[i+1]
+	}
+	for _, p := range self.keys {
+		if val, ok := given[p.name]; ok {
+			env.Def(p.name, val)
+		} else if err := bindDefault(env, p); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (self *signature) hasKey(name string) bool {
+	for _, p := range self.keys {
+		if p.name == name {
+			return true
+		}
+	}
+	return false
+}
+
+func bindDefault(env *EnvType, p param) *LispError { // 以默认值绑定省略的参数
+	val := Eval(p.def, env)
+	if err, ok := val.(*LispError); ok {
+		return err
+	}
+	env.Def(p.name, val)
+	return nil
+}
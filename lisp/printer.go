@@ -0,0 +1,120 @@
+package lisp
+
+import (
+	"fmt"
+	"math"
+	"math/big"
+	"strconv"
+	"strings"
+)
+
+func TypeOf(v Object) string { // 值的类型名
+	switch x := v.(type) {
+	case nil:
+		return "nil"
+	case int64:
+		return "整数"
+	case *big.Int:
+		return "大整数"
+	case *big.Rat:
+		return "分数"
+	case float64:
+		return "浮点数"
+	case bool:
+		return "bool"
+	case Str:
+		return "字符串"
+	case *Symbol:
+		return "符号"
+	case []Object:
+		return "列表"
+	case *HashMap:
+		return "哈希表"
+	case *Set:
+		return "集合"
+	case Fn:
+		if x.Macro {
+			return "宏"
+		}
+		return "函数"
+	case func([]Object) Object:
+		return "系统函数"
+	case *Module:
+		return "模块"
+	case ErrorValue:
+		return "错误"
+	}
+	return fmt.Sprintf("%T", v)
+}
+
+func ToString(v Object, readable bool) string { // 值转为字符串，readable为true时字符串带引号，可被重新读入
+	switch x := v.(type) {
+	case nil:
+		return "nil"
+	case Str:
+		if readable {
+			return quote(string(x))
+		}
+		return string(x)
+	case float64:
+		s := strconv.FormatFloat(x, 'g', -1, 64)
+		if x == math.Trunc(x) && !strings.ContainsAny(s, "e.InN") { // 整数值的浮点数加上.0，与整数区分
+			s += ".0"
+		}
+		return s
+	case *big.Rat:
+		return x.RatString()
+	case []Object:
+		items := make([]string, len(x))
+		for i, item := range x {
+			items[i] = ToString(item, readable)
+		}
+		return "(" + strings.Join(items, " ") + ")"
+	case *HashMap:
+		items := make([]string, 0, 2*x.Len())
+		for _, hk := range x.order {
+			e := x.entries[hk]
+			items = append(items, ToString(e.Key, readable), ToString(e.Val, readable))
+		}
+		return "%{" + strings.Join(items, " ") + "}"
+	case *Set:
+		items := make([]string, x.Len())
+		for i, item := range x.Items() {
+			items[i] = ToString(item, readable)
+		}
+		return "%[" + strings.Join(items, " ") + "]"
+	case func([]Object) Object:
+		return "<builtin>"
+	case Return:
+		return ToString(x.Val, readable)
+	case *LispError:
+		return x.Error()
+	}
+	return fmt.Sprint(v)
+}
+
+func quote(s string) string { // 加上引号，只使用读取时支持的转义 \n \t \r \" \\ \uXXXX，其余字符原样写出
+	var buf strings.Builder
+	buf.WriteByte('"')
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; c {
+		case '\n':
+			buf.WriteString(`\n`)
+		case '\t':
+			buf.WriteString(`\t`)
+		case '\r':
+			buf.WriteString(`\r`)
+		case '"', '\\':
+			buf.WriteByte('\\')
+			buf.WriteByte(c)
+		default:
+			if c < 0x20 || c == 0x7f { // 其余控制字符
+				fmt.Fprintf(&buf, `\u%04x`, c)
+			} else {
+				buf.WriteByte(c)
+			}
+		}
+	}
+	buf.WriteByte('"')
+	return buf.String()
+}
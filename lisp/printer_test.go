@@ -0,0 +1,33 @@
+package lisp
+
+import "testing"
+
+func TestReadable(t *testing.T) { // 以 readable 输出的值应能被重新读入
+	expect(t, [][2]string{
+		{`(val "a\"b\\c\nd\te\r")`, `"a\"b\\c\nd\te\r"`},
+		{`(val "\u0007\u0000中文")`, `"\u0007\u0000中文"`},
+		{"(/ 10 3)", "10/3"},
+		{"(val 10/3)", "10/3"},
+		{"(val -4/6)", "-2/3"},
+		{"(val 4/2)", "2"},
+		{"(+ 1/3 1/6)", "1/2"},
+		{"(val 100000000000000000000/3)", "100000000000000000000/3"},
+		{"(* 1e10 1e11)", "1e+21"},
+		{"(val 1e+21)", "1e+21"},
+		{"(val 1.5e-7)", "1.5e-07"},
+		{"(val 2E3)", "2000.0"},
+		{"(val 1/0)", "1:6: 语法错误: 无效的数字 1/0"},
+		{"(val 1/-2)", "1:6: 语法错误: 无效的数字 1/-2"},
+		{"(val 1.5/2)", "1:6: 语法错误: 无效的数字 1.5/2"},
+	})
+	in := New()
+	for _, src := range []string{`"\u0001\n\"x\\"`, "-7/3", "1e-07", "123456789012345678901234567890", `(1/2 "a\tb" (2.5e+30))`} {
+		trees, err := in.ReadBlock(src)
+		if err != nil || len(trees) != 1 {
+			t.Fatalf("%v: %v", src, err)
+		}
+		if got := ToString(trees[0], true); got != src {
+			t.Errorf("%v 读入后输出为 %v", src, got)
+		}
+	}
+}
@@ -0,0 +1,383 @@
+package lisp
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+func IsSym(v byte) bool { // 括号判断
+	syms := "(){}[]"
+	for i := 0; i < len(syms); i++ {
+		if syms[i] == v {
+			return true
+		}
+	}
+	return false
+}
+
+func IsNum(v byte) bool { // 数字判断
+	if v >= '0' && v <= '9' {
+		return true
+	}
+	return false
+}
+
+func IsSpace(v byte) bool { // 空白判断
+	return v == ' ' || v == '\t' || v == '\r' || v == '\n'
+}
+
+type Pos struct { // 源码位置，行列均从1开始
+	File string
+	Line int
+	Col  int
+}
+
+func (self Pos) String() string {
+	if self.File == "" {
+		return fmt.Sprintf("%v:%v", self.Line, self.Col)
+	}
+	return fmt.Sprintf("%v:%v:%v", self.File, self.Line, self.Col)
+}
+
+type NodePos struct { // 列表节点位置
+	Pos   Pos   // 左括号位置
+	Items []Pos // 每个元素的位置
+}
+
+type Source struct { // 一段待读取的源码
+	Src   string // 源码
+	File  string // 所在文件
+	Line  int    // 起始行
+	Col   int    // 起始列
+	Block bool   // 是否为S:代码块，代码块中可换行，# 开始行注释
+}
+
+type Code struct {
+	src    string // 源码
+	pos    int    // 当前指针位置
+	tokens []Object
+
+	file  string
+	block bool
+	line  int                  // 指针所在行
+	col   int                  // 指针所在列
+	at    Pos                  // 当前token位置
+	Nodes map[*Object]*NodePos // 列表节点位置表，为nil时不记录
+}
+
+/**词法分析开始**/
+func (self *Code) Init(src string) { // 初始化
+	self.InitSource(Source{Src: src, Line: 1, Col: 1})
+}
+func (self *Code) InitSource(s Source) { // 以带位置的源码初始化
+	self.src = s.Src
+	self.pos = 0
+	self.file = s.File
+	self.block = s.Block
+	self.line = s.Line
+	self.col = s.Col
+	self.Next() // 初始化就读一个token
+}
+func (self *Code) Peek() Object { // 返回当前token
+	if len(self.tokens) > 0 {
+		return self.tokens[len(self.tokens)-1]
+	}
+	return nil
+}
+func (self *Code) Pos() Pos { // 返回当前token位置
+	return self.at
+}
+func (self *Code) advance() { // 指针前进一个字符
+	if self.src[self.pos] == '\n' {
+		self.line++
+		self.col = 1
+		self.pos++
+		return
+	}
+	_, n := utf8.DecodeRuneInString(self.src[self.pos:])
+	self.pos += n
+	self.col++
+}
+func (self *Code) skip() { // 跳过空白及代码块中的注释
+	for self.pos < len(self.src) {
+		switch {
+		case IsSpace(self.src[self.pos]):
+			self.advance()
+		case self.block && self.src[self.pos] == '#':
+			for self.pos < len(self.src) && self.src[self.pos] != '\n' {
+				self.advance()
+			}
+		default:
+			return
+		}
+	}
+}
+func (self *Code) Next() Object { // 下一个token
+	self.skip()
+	self.at = Pos{self.file, self.line, self.col}
+	if self.pos >= len(self.src) {
+		self.tokens = append(self.tokens, nil)
+		return nil
+	}
+	var tk Object
+	var i = self.pos
+
+	switch {
+	case IsNum(self.src[i]) || (self.src[i] == '-' && i+1 < len(self.src) && IsNum(self.src[i+1])): // 读取数字
+		for self.pos < len(self.src) && (IsNum(self.src[self.pos]) || strings.IndexByte(".-/eE", self.src[self.pos]) >= 0 ||
+			self.src[self.pos] == '+' && (self.src[self.pos-1] == 'e' || self.src[self.pos-1] == 'E')) { // 含分数 10/3 及指数 1e+21
+			self.advance()
+		}
+		tk = ParseNumber(self.src[i:self.pos])
+		if tk == nil {
+			tk = NewError(SyntaxError, "无效的数字 %v", self.src[i:self.pos]).setPos(self.at)
+		}
+	case self.src[i] == '"': // 字符串
+		tk = self.read_str()
+	case self.src[i] == '\'' || self.src[i] == '`' || self.src[i] == '~': // 引用前缀 ' ` ~ ~@
+		self.advance()
+		if self.src[i] == '~' && self.pos < len(self.src) && self.src[self.pos] == '@' {
+			self.advance()
+		}
+		tk = self.src[i:self.pos]
+	case self.src[i] == '%' && i+1 < len(self.src) && (self.src[i+1] == '{' || self.src[i+1] == '['): // 哈希表 %{ 与集合 %[ 字面量
+		self.advance()
+		self.advance()
+		tk = self.src[i:self.pos]
+	case IsSym(self.src[i]): // 括号判断
+		self.advance()
+		tk = self.src[i:self.pos]
+	default: // 变量||函数
+		for self.pos < len(self.src) && !IsSpace(self.src[self.pos]) && !IsSym(self.src[self.pos]) {
+			if self.block && self.src[self.pos] == '#' {
+				break
+			}
+			self.advance()
+		}
+		switch name := self.src[i:self.pos]; name {
+		case "true":
+			tk = true
+		case "false":
+			tk = false
+		default:
+			tk = Sym(name)
+		}
+	}
+	self.tokens = append(self.tokens, tk)
+	return tk
+}
+
+func (self *Code) read_str() Object { // 读取字符串字面量，代码块中可跨行
+	at := self.at
+	var buf strings.Builder
+	self.advance() // 跳过左引号
+	for self.pos < len(self.src) {
+		c := self.src[self.pos]
+		switch {
+		case c == '"':
+			self.advance()
+			return Str(buf.String())
+		case c == '\\':
+			esc := Pos{self.file, self.line, self.col}
+			self.advance()
+			if self.pos >= len(self.src) {
+				break
+			}
+			e := self.src[self.pos]
+			self.advance()
+			switch e {
+			case 'n':
+				buf.WriteByte('\n')
+			case 't':
+				buf.WriteByte('\t')
+			case 'r':
+				buf.WriteByte('\r')
+			case '"', '\\':
+				buf.WriteByte(e)
+			case 'u': // \uXXXX
+				if self.pos+4 > len(self.src) {
+					return NewError(SyntaxError, "\\u 之后应为4位十六进制数").setPos(esc)
+				}
+				r, err := strconv.ParseUint(self.src[self.pos:self.pos+4], 16, 32)
+				if err != nil {
+					return NewError(SyntaxError, "\\u 之后应为4位十六进制数").setPos(esc)
+				}
+				for k := 0; k < 4; k++ {
+					self.advance()
+				}
+				buf.WriteRune(rune(r))
+			default:
+				return NewError(SyntaxError, "未知的转义字符 \\%c", e).setPos(esc)
+			}
+		case c == '\n' && !self.block:
+			self.pos = len(self.src)
+		default:
+			start := self.pos
+			self.advance()
+			buf.WriteString(self.src[start:self.pos])
+		}
+	}
+	return NewError(SyntaxError, "字符串缺少右引号").setPos(at)
+}
+
+var closers = map[Object]Object{"(": ")", "[": "]", "{": "}", "%{": "}", "%[": "]"}
+
+var literals = map[Object]string{"%{": "hash-map", "%[": "hash-set"} // 字面量对应的构造函数，%{k v} 即 (hash-map k v)
+
+var quotes = map[Object]string{"'": "quote", "`": "quasiquote", "~": "unquote", "~@": "splice-unquote"} // 引用前缀对应的形式
+
+func (self *Code) read_list(open Object, at Pos) Object { // 读列表
+	var lt []Object
+	var items []Pos
+	if head, ok := literals[open]; ok {
+		lt, items = []Object{Sym(head)}, []Pos{at}
+	}
+	v := self.Next()
+	for v != ")" && v != "]" && v != "}" && v != nil {
+		items = append(items, self.at)
+		sub := self.read_form()
+		if IsError(sub) {
+			return sub
+		}
+		lt = append(lt, sub)
+		v = self.Next()
+	}
+	if v == nil {
+		return NewError(SyntaxError, "缺少与 %v 匹配的 %v", open, closers[open]).setPos(at)
+	}
+	if v != closers[open] {
+		return NewError(SyntaxError, "括号不匹配：%v 与 %v", open, v).setPos(self.at)
+	}
+	self.record(lt, at, items)
+	return lt
+}
+
+func (self *Code) record(lt []Object, at Pos, items []Pos) { // 记录列表节点位置
+	if self.Nodes != nil && len(lt) > 0 {
+		self.Nodes[&lt[0]] = &NodePos{at, items}
+	}
+}
+
+func (self *Code) read_form() Object { // 读取从当前token开始的一个表达式
+	v := self.Peek()
+	switch v {
+	case "(", "[", "{", "%{", "%[": // 读列表
+		return self.read_list(v, self.at)
+	case ")", "]", "}":
+		return NewError(SyntaxError, "多余的 %v", v).setPos(self.at)
+	case "'", "`", "~", "~@": // 'x 即 (quote x)
+		at := self.at
+		if self.Next() == nil {
+			return NewError(SyntaxError, "%v 之后缺少表达式", v).setPos(at)
+		}
+		form_at := self.at
+		form := self.read_form()
+		if IsError(form) {
+			return form
+		}
+		lt := []Object{Sym(quotes[v]), form}
+		self.record(lt, at, []Pos{at, form_at})
+		return lt
+	}
+	return v
+}
+
+func (self *Code) Read_Root() Object { // 根节点开始解析
+	return self.read_form()
+}
+
+func (self *Code) ReadAll() []Object { // 读取全部根节点，遇到语法错误时以错误结尾
+	var res []Object
+	for self.Peek() != nil {
+		tree := self.Read_Root()
+		res = append(res, tree)
+		if IsError(tree) {
+			break
+		}
+		self.Next()
+	}
+	return res
+}
+
+/**词法分析结束**/
+
+func findExpr(s string) (int, int) { // 找出字符串中的语句位置
+	var x, y int
+	for i := 0; i < len(s); i++ {
+		if s[i] == '(' {
+			x = i
+			break
+		}
+	}
+	for i := len(s) - 1; i > 0; i-- {
+		if s[i] == ')' {
+			y = i
+			break
+		}
+	}
+	return x, y
+}
+
+func FindExpr(s string) string { // 找出字符串中的语句
+	x, y := findExpr(s)
+	if x < y { // 找到
+		return s[x : y+1]
+	} else {
+		return "" // 找不到
+	}
+}
+
+func Unclosed(src string) bool { // 括号未闭合或字符串未结束，交互输入时据此继续读入下一行
+	depth := 0
+	for i := 0; i < len(src); i++ {
+		switch src[i] {
+		case '"':
+			for i++; i < len(src) && src[i] != '"'; i++ {
+				if src[i] == '\\' { // 跳过转义字符
+					i++
+				}
+			}
+			if i >= len(src) {
+				return true
+			}
+		case '#': // 行注释
+			for i < len(src) && src[i] != '\n' {
+				i++
+			}
+		case '(', '{', '[':
+			depth++
+		case ')', '}', ']':
+			depth--
+		}
+	}
+	return depth > 0
+}
+
+func SplitSource(src, file string) []Source { // 按代码文件规则找出源码中的代码片段
+	cmds := strings.Split(src, "\n") // 分离语句
+	var res []Source
+	for i := 0; i < len(cmds); i++ {
+		sr := strings.Trim(cmds[i], "\r")
+		if strings.Trim(sr, " ") == "S:" { // 代码区, 代码区仅提供行注释，注释可用# 分割
+			start := i + 1
+			for i+1 < len(cmds) {
+				i++
+				if strings.Trim(strings.Trim(cmds[i], "\r"), " ") == ":E" {
+					break
+				}
+			}
+			end := i
+			if end == len(cmds)-1 && strings.Trim(strings.Trim(cmds[end], "\r"), " ") != ":E" {
+				end = len(cmds) // 没有:E时代码区直到文件末尾
+			}
+			if start < end {
+				res = append(res, Source{strings.Join(cmds[start:end], "\n"), file, start + 1, 1, true})
+			}
+		} else if x, y := findExpr(sr); x < y { // 任何非表达式都是注释
+			res = append(res, Source{sr[x : y+1], file, i + 1, utf8.RuneCountInString(sr[:x]) + 1, false})
+		}
+	}
+	return res
+}
@@ -0,0 +1,193 @@
+package lisp
+
+// 异常处理：(throw v) 抛出任意值，(try {expr ...} (catch e {expr ...}) (finally {expr ...})) 捕获
+// 解释器报告的错误与 throw 抛出的值一样可以捕获，捕获后是普通的值 ErrorValue
+
+type ErrorValue struct { // 被 catch 捕获的错误
+	Err *LispError
+}
+
+func (self ErrorValue) String() string {
+	return "<error " + self.Err.Error() + ">"
+}
+
+type tryForm struct {
+	body    []Object
+	name    *Symbol  // catch 的变量名，没有 catch 时为nil
+	handler []Object // catch 的语句块
+	finally []Object // finally 的语句块
+	hasFin  bool     // 是否有 finally
+}
+
+func parseTry(v []Object) (*tryForm, *LispError) { // 检查 try 的结构
+	syntax := func(i int) *LispError {
+		err := NewError(SyntaxError, "try 结构错误！正确格式为：(try {expr1 expr2 ...} (catch e {expr1 expr2 ...}) (finally {expr1 expr2 ...}))")
+		if i >= 0 {
+			err.at(v, i)
+		}
+		return err
+	}
+	if len(v) < 3 || len(v) > 4 {
+		return nil, syntax(-1)
+	}
+	t := &tryForm{}
+	var ok bool
+	if t.body, ok = v[1].([]Object); !ok {
+		return nil, syntax(1)
+	}
+	for i := 2; i < len(v); i++ {
+		c, ok := v[i].([]Object)
+		if !ok || len(c) < 2 {
+			return nil, syntax(i)
+		}
+		head, _ := c[0].(*Symbol)
+		switch {
+		case head != nil && head.Name == "catch" && len(c) == 3 && t.name == nil && !t.hasFin: // catch 只能有一个，且在 finally 之前
+			t.name, _ = c[1].(*Symbol)
+			t.handler, ok = c[2].([]Object)
+			if t.name == nil || !ok {
+				return nil, syntax(i)
+			}
+		case head != nil && head.Name == "finally" && len(c) == 2 && !t.hasFin:
+			if t.finally, ok = c[1].([]Object); !ok {
+				return nil, syntax(i)
+			}
+			t.hasFin = true
+		default:
+			return nil, syntax(i)
+		}
+	}
+	return t, nil
+}
+
+func caught(err *LispError, env *EnvType) ErrorValue { // 捕获错误，先补全出错位置
+	if env.in != nil {
+		env.in.locate(err)
+	}
+	return ErrorValue{err}
+}
+
+func evalBody(exprs []Object, env *EnvType) Object { // 依次执行语句块，返回最后一个表达式的值，遇到返回或错误时立即返回
+	var res Object
+	for _, expr := range exprs {
+		res = Eval(expr, env)
+		switch res.(type) {
+		case Return, *LispError:
+			return res
+		}
+	}
+	return res
+}
+
+func evalTry(v []Object, env *EnvType) Object { // 执行 try，结果为语句块或 catch 最后一个表达式的值
+	t, err := parseTry(v)
+	if err != nil {
+		return err
+	}
+	res := resolve(evalBody(t.body, env.Copy())) // 尾调用在 try 内执行，其中的错误才能被捕获
+	if err, ok := res.(*LispError); ok && t.name != nil {
+		catch_env := env.Copy()
+		catch_env.Def(t.name.Name, caught(err, env))
+		res = resolve(evalBody(t.handler, catch_env))
+	}
+	if t.hasFin { // finally 中返回或出错时取代原来的结果
+		if fin := evalBlock(t.finally, env.Copy()); fin != nil {
+			return fin
+		}
+	}
+	return res
+}
+
+func (self *closureCompiler) try(v []Object, sc *scope) closure {
+	t, err := parseTry(v)
+	if err != nil {
+		return func(*EnvType) Object {
+			_, err := parseTry(v) // 每次报告新的错误值
+			return err
+		}
+	}
+	bs, cs, fs := &scope{up: sc}, &scope{up: sc}, &scope{up: sc}
+	body := self.body(t.body, bs)
+	var handler, fin closure
+	slot := -1
+	if t.name != nil {
+		slot = cs.declare(t.name.Name)
+		handler = self.body(t.handler, cs)
+	}
+	if t.hasFin {
+		fin = self.block(t.finally, fs)
+	}
+	return func(env *EnvType) Object {
+		res := resolve(body(env.block(bs)))
+		if err, ok := res.(*LispError); ok && handler != nil {
+			catch_env := env.block(cs)
+			catch_env.define(slot, t.name.Name, caught(err, env))
+			res = resolve(handler(catch_env))
+		}
+		if fin != nil {
+			if x := fin(env.block(fs)); x != nil {
+				return x
+			}
+		}
+		return res
+	}
+}
+
+func errArg(name string, v []Object) (*LispError, *LispError) { // 取出唯一的参数作为捕获的错误
+	if err := checkArity(name, v, 1, 1); err != nil {
+		return nil, err
+	}
+	e, ok := v[0].(ErrorValue)
+	if !ok {
+		err := NewError(TypeError, "%v 的参数应为错误, 实际为 %v", name, ToString(v[0], true))
+		err.idx = 1
+		return nil, err
+	}
+	return e.Err, nil
+}
+
+func errorBuiltins() map[string]Object {
+	return map[string]Object{
+		"throw": func(v []Object) Object { // (throw v) 抛出任意值，抛出捕获的错误时保留原来的类别与位置
+			if err := checkArity("throw", v, 1, 1); err != nil {
+				return err
+			}
+			if e, ok := v[0].(ErrorValue); ok {
+				err := *e.Err
+				err.trace = append([]errNode(nil), err.trace...)
+				return &err
+			}
+			err := NewError(UserError, "%v", ToString(v[0], false))
+			err.Val = v[0]
+			return err
+		},
+		"error?": func(v []Object) Object {
+			if err := checkArity("error?", v, 1, 1); err != nil {
+				return err
+			}
+			_, ok := v[0].(ErrorValue)
+			return ok
+		},
+		"error-message": func(v []Object) Object { // 错误信息，不含位置
+			e, err := errArg("error-message", v)
+			if err != nil {
+				return err
+			}
+			return Str(e.Msg)
+		},
+		"error-kind": func(v []Object) Object { // 错误类别，如 "类型错误"，throw 抛出的为 "用户错误"
+			e, err := errArg("error-kind", v)
+			if err != nil {
+				return err
+			}
+			return Str(e.Kind.String())
+		},
+		"error-value": func(v []Object) Object { // throw 抛出的值，解释器报告的错误为nil
+			e, err := errArg("error-value", v)
+			if err != nil {
+				return err
+			}
+			return e.Val
+		},
+	}
+}
@@ -0,0 +1,177 @@
+package lisp
+
+// 字节码虚拟机：在栈上执行 Proto，变量仍保存在 EnvType 中，因此可与 Eval 互相调用
+
+func (self *Proto) run(env *EnvType) Object {
+	stack := make([]Object, 0, 16)
+	var envs []*EnvType // 进入语句块前的环境
+	code := self.code
+	for pc := 0; pc < len(code); pc++ {
+		in := code[pc]
+		switch in.Op {
+		case opConst:
+			stack = append(stack, self.consts[in.A])
+		case opGet:
+			sym := self.consts[in.A].(*Symbol)
+			val, ok := env.Lookup(sym.Name)
+			if !ok {
+				if env.Strict() {
+					return self.fail(NewError(UnboundSymbol, "未定义的变量 %v", sym), pc)
+				}
+				val = sym // 非严格模式下未定义的符号求值为自身
+			}
+			stack = append(stack, val)
+		case opSet, opDef, opAssign:
+			name := self.consts[in.A].(*Symbol).Name
+			val := stack[len(stack)-1]
+			switch in.Op {
+			case opSet:
+				env.Set(name, val)
+			case opDef:
+				env.Def(name, val)
+			default:
+				if !env.Assign(name, val) {
+					return self.fail(NewError(UnboundSymbol, "set! 的变量 %v 未定义", name), pc)
+				}
+			}
+		case opPop:
+			stack = stack[:len(stack)-1]
+		case opJump:
+			pc = int(in.A) - 1
+		case opJumpFalse:
+			cond := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			du, ok := cond.(bool)
+			if !ok {
+				return self.fail(NewError(TypeError, "%v 的判断条件应为bool, 实际为 %v", self.consts[in.B], cond), pc)
+			}
+			if !du {
+				pc = int(in.A) - 1
+			}
+		case opMatch:
+			if matches(stack[len(stack)-1], self.consts[in.B].([]Object)) {
+				stack = stack[:len(stack)-1]
+			} else {
+				pc = int(in.A) - 1
+			}
+		case opStmt:
+			if _, ok := stack[len(stack)-1].(Return); ok {
+				pc = int(in.A) - 1
+			} else {
+				stack = stack[:len(stack)-1]
+			}
+		case opEnter:
+			envs = append(envs, env)
+			env = env.Copy()
+		case opLeave:
+			env = envs[len(envs)-1]
+			envs = envs[:len(envs)-1]
+		case opGetFn, opGetFnTail:
+			sym := self.consts[in.A].(*Symbol)
+			f, ok := env.Lookup(sym.Name)
+			if !ok {
+				return self.fail(NewError(UnboundSymbol, "未定义的函数 %v", sym.Name), pc)
+			}
+			if fc, ok := f.(Fn); ok && fc.Macro { // 宏交给 Eval 展开并求值
+				var res Object
+				if node := self.nodes[self.sites[pc].node]; in.Op == opGetFnTail {
+					res = evalTail(node, env)
+				} else {
+					res = Eval(node, env)
+				}
+				if IsError(res) {
+					return res
+				}
+				stack = append(stack, res)
+				pc = int(in.B) - 1
+				continue
+			}
+			if !IsFunc(f) {
+				return self.fail(NewError(TypeError, "%v 不是函数", sym.Name), pc)
+			}
+			stack = append(stack, f)
+		case opCheckFn:
+			if f := stack[len(stack)-1]; !IsFunc(f) {
+				return self.fail(NewError(TypeError, "%v 不是函数", ToString(f, true)), pc)
+			}
+		case opCall, opTailCall:
+			n := len(stack) - int(in.A)
+			f := stack[n-1]
+			args := make([]Object, in.A) // 函数可能保留参数列表，不能直接使用栈
+			copy(args, stack[n:])
+			stack = stack[:n-1]
+			if fc, ok := f.(Fn); ok && in.Op == opTailCall {
+				stack = append(stack, &tailCall{fc, args})
+				break
+			}
+			res := Invoke(f, args)
+			if err, ok := res.(*LispError); ok {
+				return self.fail(err, pc)
+			}
+			stack = append(stack, res)
+		case opList:
+			n := len(stack) - int(in.A)
+			lt := make([]Object, in.A)
+			copy(lt, stack[n:])
+			stack = append(stack[:n], lt)
+		case opRet:
+			stack[len(stack)-1] = Return{stack[len(stack)-1]}
+		case opFn:
+			p := self.protos[in.A]
+			fn := Fn{Name: p.name, Args: p.args, sig: p.sig, Body: p.body, Env: env, run: p.run}
+			if in.B == 1 {
+				env.Set(fn.Name, fn)
+			}
+			stack = append(stack, fn)
+		case opEval:
+			res := Eval(self.nodes[self.sites[pc].node], env)
+			if IsError(res) {
+				return res
+			}
+			stack = append(stack, res)
+		case opLoop:
+			if ctl, ok := loopSignal(stack[len(stack)-1], string(self.consts[in.B].(Str))); ok {
+				if ctl.brk {
+					stack[len(stack)-1] = nil
+				} else {
+					stack = stack[:len(stack)-1]
+					pc = int(in.A) - 1
+				}
+			}
+		case opIter:
+			n, item, err := eachItems(string(self.consts[in.A].(Str)), stack[len(stack)-1])
+			if err != nil {
+				return self.fail(err, pc)
+			}
+			stack[len(stack)-1] = &iterState{n: n, item: item}
+		case opNext:
+			it := stack[len(stack)-1].(*iterState)
+			if it.i >= it.n {
+				pc = int(in.A) - 1
+				break
+			}
+			stack = append(stack, it.item(it.i))
+			it.i++
+		case opUnpack:
+			parts, err := splitItem(string(self.consts[in.B].(Str)), stack[len(stack)-1], int(in.A))
+			if err != nil {
+				return self.fail(err, pc)
+			}
+			stack = append(stack[:len(stack)-1], parts...)
+		case opNip:
+			stack[len(stack)-2] = stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+		case opError:
+			err := *self.consts[in.A].(*LispError) // 每次报告新的错误值
+			return self.fail(&err, pc)
+		}
+	}
+	return stack[len(stack)-1]
+}
+
+func (self *Proto) fail(err *LispError, pc int) *LispError { // 在错误中记录指令对应的节点
+	if s := self.sites[pc]; s.node >= 0 {
+		err.at(self.nodes[s.node], int(s.idx))
+	}
+	return err
+}
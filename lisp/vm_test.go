@@ -0,0 +1,209 @@
+package lisp
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+const perNum = `
+S:
+(fn is [m] {
+    (set sum 0)
+    (set i 1)
+    (for (< i m) {
+        (if (== 0 (% m i)) {(= sum (+ sum i))})
+        (= i (+ i 1))
+    })
+    (if (== m sum) {(ret true)} {(ret false)})
+})
+(fn find [v k] {
+    (for (<= v k) {
+        (if (is v) {(out v)})
+        (= v (+ v 1))
+    })
+})
+(fn fib [n] {(if (<= n 2) {(ret 1)} {(ret (+ (fib (- n 1)) (fib (- n 2))))})})
+:E
+`
+
+func run(engine Engine, src string) string { // 以指定方式执行，返回输出、结果及错误
+	var out bytes.Buffer
+	in := New()
+	in.Out = &out
+	in.Engine = engine
+	res, err := in.Eval(src)
+	return fmt.Sprintf("%v|%v|%v", out.String(), ToString(res, true), err)
+}
+
+var progs = [][2]string{ // 各种执行方式的输出、结果及错误都应为第二项
+	{perNum + "(find 1 500) (fib 15)",
+		"6\n28\n496\n|610|<nil>"},
+	{"(+ 1 \"a\")",
+		"|nil|1:6: 类型错误: + 的第2个参数应为数字, 实际为 a"},
+	{"(fn f [x] {(if (> x 0) {(ret x)}) (ret 0)}) (out (f 3) (f -1)) (f 1 2)",
+		"3 0\n|nil|1:64: 参数个数错误: f 需要1个参数, 实际为2个"},
+	{"(out (if true {1})) (if 1 {2})",
+		"nil\n|nil|1:25: 类型错误: if 的判断条件应为bool, 实际为 1"},
+	{"(let [a 1 b 2] {(out a) (+ a b)})",
+		"1\n|3|<nil>"},
+	{"(fn m [] {(for true {(ret 7)})}) (m)",
+		"|7|<nil>"},
+	{"(fn mk [n] {(ret (lambda [x] {(ret (+ x n))}))}) (map (mk 1) (list 1 2))",
+		"|(2 3)|<nil>"},
+	{"(defmacro my-unless [c b] {(ret `(if (! ~c) ~b))}) (my-unless false {(out \"ok\")}) (my-unless 1 {2})",
+		"ok\n|nil|1:83: 类型错误: ! 的第1个参数应为bool, 实际为 1"},
+	{"(fn loop [n] {(if (== n 0) {(ret 0)}) (ret (loop (- n 1)))}) (loop 100000)",
+		"|0|<nil>"},
+	{"(set! nosuch 1)",
+		"|nil|1:7: 未定义符号: set! 的变量 nosuch 未定义"},
+	{"(fn h [] {(ret (+ 1 (nosuch)))}) (h)",
+		"|nil|1:22: 未定义符号: 未定义的函数 nosuch"},
+	{"(fn g [1] {}) (g)",
+		"|nil|1:7: 语法错误: g 的形参应为符号, 实际为 1"},
+	{"((lambda [x] {(ret (* x x))}) 5)",
+		"|25|<nil>"},
+	{"(out `(1 ~(+ 1 1) ~@(list 3 4)))",
+		"(1 2 3 4)\n|nil|<nil>"},
+	{"(set x 1) (fn f [] {(set x 2) (if true {(def x 3) (set x 4) (out x)}) (out x)}) (f) (out x)",
+		"4\n2\n1\n|nil|<nil>"},
+	{"(fn g [] {(out y) (def y 5) (out y)}) (g)",
+		"y\n5\n|nil|<nil>"},
+	{"(defmacro defv [n v] {(ret `(def ~n ~v))}) (fn m [] {(defv w 9) (set w (+ w 1)) (ret w)}) (m)",
+		"|10|<nil>"},
+	{"(fn k [] {(let [p 1 q p] {(ret (+ p q))})}) (k)",
+		"|2|<nil>"},
+	{"(set n 0) (fn inc [] {(set! n (+ n 1))}) (inc) (inc) n",
+		"|nil|<nil>"},
+	{"(fn f [x] {(if (< x 0) {(throw (list \"neg\" x))}) (ret (* x 2))}) (fn safe [x] {(try {(ret (f x))} (catch e {(ret (error-value e))}) (finally {(out x)}))}) (list (safe 1) (safe -1))",
+		"1\n-1\n|(2 (\"neg\" -1))|<nil>"},
+	{"(try {(/ 1 0)} (catch e {(list (error-kind e) (error-message e))}))",
+		"|(\"算术错误\" \"除数为0\")|<nil>"},
+	{"(try {(throw 1)} (catch e {(throw e)}))",
+		"|nil|1:7: 用户错误: 1"},
+	{"(try {1} (finally {(throw \"fin\")}))",
+		"|nil|1:20: 用户错误: fin"},
+	{"(set i 0) (for outer (< i 3) {(= i (+ i 1)) (set j 0) (for (< j 3) {(= j (+ j 1)) (if (== j 2) {(continue outer)}) (if (== i 3) {(break outer)}) (out i j)})}) (list i j)",
+		"1 1\n2 1\n|(3 1)|<nil>"},
+	{"(fn f [] {(break)}) (set k 0) (for (< k 3) {(= k (+ k 1)) (f)})",
+		"|nil|1:11: 语法错误: break 只能在循环中使用"},
+	{"(for-each [c \"ab\"] {(out c)}) (for-each [k v (hash-map 1 2)] {(out k v)}) (for-each out (range 3 0 -1)) (for-each [x 5] {})",
+		"a\nb\n1 2\n3\n2\n1\n|nil|1:115: 类型错误: for-each 只能遍历列表、字符串、集合或哈希表, 实际为 5"},
+	{"(fn f [lst] {(for-each outer [x lst] {(dotimes [i x] {(if (== i 1) {(continue outer)}) (if (== x 3) {(ret x)}) (out x i)})}) (ret 0)}) (f (list 1 2 3 4))",
+		"1 0\n2 0\n|3|<nil>"},
+	{"(fn sign [n] {(cond (< n 0) {(ret -1)} (== n 0) {(ret 0)} else {(ret 1)})}) (out (sign -5) (sign 0) (sign 2)) (cond 1 {2})",
+		"-1 0 1\n|nil|1:117: 类型错误: cond 的判断条件应为bool, 实际为 1"},
+	{"(fn day [d] {(case d (6 7) {(ret \"weekend\")} \"x\" {(ret 0)} else {(unless (> d 0) {(ret nil)}) (ret \"weekday\")})}) (out (day 6) (day \"x\") (day 3) (day 0)) (switch 1 2 {(out 2)})",
+		"weekend 0 weekday nil\n|nil|<nil>"},
+	{"(set i 0) (for (< i 5) {(= i (+ i 1)) (when (== i 2) {(continue)}) (unless (< i 4) {(break)}) (out i)}) (when 1 {}) (case 1)",
+		"1\n3\n|nil|1:111: 类型错误: when 的判断条件应为bool, 实际为 1"},
+	{"(fn f [a (b 10) (c (* b 2))] {(ret (list a b c))}) (out (f 1) (f 1 2) (f 1 2 3)) (f)",
+		"(1 10 20) (1 2 4) (1 2 3)\n|nil|1:82: 参数个数错误: f 需要1到3个参数, 实际为0个"},
+	{"(fn h [a & rest] {(ret (list a rest))}) (out (h 1) (apply h 1 (list 2 3))) ((lambda [x] {(ret x)}) 1 2)",
+		"(1 ()) (1 (2 3))\n|nil|1:76: 参数个数错误: lambda 需要1个参数, 实际为2个"},
+	{"(fn scale [x &key (by 2) plus] {(if (== plus nil) {(ret (* x by))}) (ret (+ (* x by) plus))}) (out (scale 3) (scale 3 :plus 1 :by 10)) (scale 3 :nope 1)",
+		"6 31\n|nil|1:136: 参数个数错误: scale 没有命名参数 :nope"},
+	{"(defmacro my-when [c & body] {(ret `(if ~c {~@body}))}) (my-when true (out 1) (out 2)) (fn bad [a (b 1) c] {})",
+		"1\n2\n|nil|1:96: 语法错误: bad 的参数 c 应在有默认值的参数之前"},
+	{"(fn f [a (b 1) & a] {})",
+		"|nil|1:7: 语法错误: f 的形参 a 重复"},
+	{"(out 1) (lambda [x y &key (x 2)] {})",
+		"1\n|nil|1:17: 语法错误: lambda 的形参 x 重复"},
+	{"(defmacro m [a a] {})",
+		"|nil|1:13: 语法错误: m 的形参 a 重复"},
+	{"(set fs (list)) (dotimes [i 3] {(set! fs (cons (lambda [] {(ret i)}) fs))}) (map (lambda [f] {(ret (f))}) fs)",
+		"|(2 1 0)|<nil>"},
+	{"(set n 0) (dotimes outer [i 4] {(for-each [k v (hash-map 1 2 3 4)] {(if (== i 1) {(continue outer)}) (if (== i 3) {(break outer)}) (set! n (+ n (* k v)))}) (when (== i 2) {(break)})}) (out n) (for-each [c \"abc\"] {(if (== c \"b\") {(continue)}) (out c)}) (for-each [x (list 1 2)] {(break)})",
+		"28\na\nc\n|nil|<nil>"},
+}
+
+func checkProgs(t *testing.T, engine Engine, name string) {
+	for _, p := range progs {
+		if got := run(engine, p[0]); got != p[1] {
+			t.Errorf("%v\n%v: %q\nwant: %q", p[0], name, got, p[1])
+		}
+	}
+}
+
+func TestTreeWalk(t *testing.T) { checkProgs(t, TreeWalk, "tree walk") }
+func TestBytecode(t *testing.T) { checkProgs(t, Bytecode, "bytecode") }
+func TestClosure(t *testing.T)  { checkProgs(t, Closure, "closure") }
+
+func TestBytecodeCache(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lisp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "per_num.txt")
+	src := perNum + "(find 1 500)\n(out (fib 10))\n(fib \"x\")\n"
+	if err := ioutil.WriteFile(path, []byte(src), 0644); err != nil {
+		t.Fatal(err)
+	}
+	var outputs []string
+	for i := 0; i < 2; i++ { // 第一次编译并写入缓存，第二次读取缓存
+		var out bytes.Buffer
+		in := New()
+		in.Out = &out
+		in.Engine = Bytecode
+		in.Cache = true
+		if i == 1 && in.readCache(path+CacheExt, src, path) == nil {
+			t.Fatal("缓存不可用")
+		}
+		_, err := in.EvalFile(path)
+		outputs = append(outputs, fmt.Sprintf("%v|%v", out.String(), err))
+	}
+	if outputs[0] != outputs[1] || outputs[0] != "6\n28\n496\n55\n|"+path+":18:22: 类型错误: <= 的第1个参数应为数字, 实际为 x" {
+		t.Fatalf("%q", outputs)
+	}
+	if in := New(); in.readCache(path+CacheExt, src+" ", path) != nil {
+		t.Fatal("源码改变后缓存应失效")
+	}
+	in := New()
+	protos, err := in.compileBytecode(src, "", SplitSource(src, path))
+	if err != nil {
+		t.Fatal(err)
+	}
+	last := protos[len(protos)-1]
+	last.code[0] = Instr{Op: opPop} // 损坏的缓存：下标和常量都合法，但栈下溢
+	if err := in.writeCache(path+CacheExt, src, protos); err != nil {
+		t.Fatal(err)
+	}
+	if in.readCache(path+CacheExt, src, path) != nil {
+		t.Fatal("损坏的缓存应失效")
+	}
+	var out bytes.Buffer
+	in = New()
+	in.Out = &out
+	in.Engine = Bytecode
+	in.Cache = true
+	_, err = in.EvalFile(path) // 缓存失效时重新编译
+	if got := fmt.Sprintf("%v|%v", out.String(), err); got != outputs[0] {
+		t.Fatalf("%q", got)
+	}
+}
+
+func benchmark(b *testing.B, engine Engine, src string) {
+	in := New()
+	in.Out = ioutil.Discard
+	in.Engine = engine
+	if _, err := in.Eval(perNum); err != nil {
+		b.Fatal(err)
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := in.Eval(src); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkPerNumTreeWalk(b *testing.B) { benchmark(b, TreeWalk, "(find 1 1000)") }
+func BenchmarkPerNumBytecode(b *testing.B) { benchmark(b, Bytecode, "(find 1 1000)") }
+func BenchmarkPerNumClosure(b *testing.B)  { benchmark(b, Closure, "(find 1 1000)") }
+func BenchmarkFibTreeWalk(b *testing.B)    { benchmark(b, TreeWalk, "(fib 20)") }
+func BenchmarkFibBytecode(b *testing.B)    { benchmark(b, Bytecode, "(fib 20)") }
+func BenchmarkFibClosure(b *testing.B)     { benchmark(b, Closure, "(fib 20)") }